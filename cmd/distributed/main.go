@@ -1,33 +1,177 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"Firetruck-sim/pkg/clock"
 	"Firetruck-sim/pkg/message"
+	"Firetruck-sim/pkg/record"
 	"Firetruck-sim/pkg/simulation"
 	"Firetruck-sim/pkg/transport"
 )
 
+// Timeouts for request/reply exchanges
+const (
+	assignAckTimeout  = 500 * time.Millisecond
+	stateQueryTimeout = 2 * time.Second
+)
+
+// bidWindow is how long trucks collect bids for a fire. Bids expire after
+// it, so late ones are dropped rather than mixed into a later round.
+const bidWindow = time.Second
+
 func main() {
 	// Command-line flags
 	id := flag.String("id", "T1", "node identifier")
 	natsURL := flag.String("nats", "nats://127.0.0.1:4222", "NATS server URL")
-	role := flag.String("role", "truck", "role: truck, water-supply, observer")
+	network := flag.String("transport", "nats", "network transport: nats (broker) or mesh (direct TCP)")
+	listen := flag.String("listen", "127.0.0.1:7001", "mesh transport: address to accept peers on")
+	peers := flag.String("peers", "", "mesh transport: comma-separated peer or seed addresses")
+	role := flag.String("role", "truck", "role: truck, water-supply, observer, local")
+	trucks := flag.String("trucks", "T1,T2", "comma-separated truck IDs for the local role")
+	faultSpec := flag.String("faults", "", "fault injection per channel, e.g. water.req:drop=0.2,delay=300ms;*:dup=0.05")
+	faultSeed := flag.Int64("fault-seed", 1, "seed for the fault injection RNG")
+	keysFile := flag.String("keys", "", "JSON keyring of per-node HMAC secrets; enables message authentication")
+	rogue := flag.String("rogue", "", "local role only: add a rogue node impersonating this ID")
+	run := flag.String("run", "", "run ID; scopes all channels so several runs can share one broker")
+	queueSize := flag.Int("queue", 0, "bound handler delivery queues to this many messages (0 disables queues)")
+	queuePolicy := flag.String("queue-policy", "block", "delivery queue overflow policy: block, drop-oldest, drop-newest")
+	recordFile := flag.String("record", "traffic.jsonl", "recorder role: file to append recorded messages to")
+	traceFile := flag.String("trace", "", "append every message this node sends and delivers, with its clock, to this file for cmd/verify")
+	speed := flag.Float64("speed", 1, "replay: timing scale; 1 keeps the recorded timing, 2 is twice as fast, 0 sends at once")
+	clockKind := flag.String("clock", "lamport", "message timestamps: lamport, or hlc for hybrid logical clocks close to wall-clock time")
+	vclock := flag.Bool("vclock", false, "carry vector clocks on messages so concurrent bids and decisions can be detected")
+	queueShared := flag.Bool("queue-shared", false, "use one delivery queue per node instead of one per subscription")
+	causalSpec := flag.String("causal", "", "comma-separated channels to deliver in causal order, e.g. fires.alerts,coordination,inbox")
+	totalSpec := flag.String("total-order", "", "comma-separated channels every node delivers in the same order, e.g. fires.alerts,fires.decision,coordination")
+	sequencer := flag.String("sequencer", "OBSERVER", "node that numbers messages on -total-order channels")
+	checkpoint := flag.Duration("checkpoint", 0, "take a global snapshot this often and write it to snapshot-<time>.json (0 disables)")
+	nodes := flag.String("nodes", "", "comma-separated IDs of the nodes allowed to send; messages from others go to the dead-letter channel (default: any, or the local role's own nodes)")
+	codecName := flag.String("codec", "json", "wire encoding of the messages this node sends: json, or binary for smaller messages; nodes decode either")
 	flag.Parse()
 
-	// Connect to NATS
-	t, err := transport.NewNATSTransport(*id, *natsURL)
+	faults, err := transport.ParseFaultSpec(*faultSpec)
+	if err != nil {
+		log.Fatalf("Invalid -faults: %v", err)
+	}
+	newClock, err := clockFactory(*clockKind)
+	if err != nil {
+		log.Fatalf("Invalid -clock: %v", err)
+	}
+	hybrid := *clockKind == "hlc"
+	codec, err := transport.ParseCodec(*codecName)
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		log.Fatalf("Invalid -codec: %v", err)
+	}
+	var trace *record.Recorder
+	if *traceFile != "" {
+		trace, err = record.NewRecorder(*traceFile)
+		if err != nil {
+			log.Fatalf("Invalid -trace: %v", err)
+		}
+		defer trace.Close()
+	}
+	var causal []string
+	if *causalSpec != "" {
+		causal = strings.Split(*causalSpec, ",")
+	}
+	ordering := ordering{sequencer: *sequencer}
+	if *totalSpec != "" {
+		ordering.channels = strings.Split(*totalSpec, ",")
+	}
+
+	// Every node rejects malformed messages, and those from unknown senders
+	limits := message.Limits{GridSize: simulation.GridSize}
+	if *nodes != "" {
+		limits.Nodes = idSet(strings.Split(*nodes, ","))
+	} else if *role == "local" {
+		limits.Nodes = idSet(append(strings.Split(*trucks, ","), "OBSERVER", "WATER-SUPPLY", "CONTROL"))
+	}
+
+	// Options for nodes that cannot sign, and for those holding the keyring
+	rogueOpts := []transport.Option{
+		transport.WithValidation(message.NewRegistry(limits)),
+		transport.WithTTL(transport.ChannelFireBids, bidWindow),
+		transport.WithCodec(codec),
+	}
+	if *run != "" {
+		rogueOpts = append(rogueOpts, transport.WithNamespace(*run))
+	}
+	if *vclock {
+		rogueOpts = append(rogueOpts, transport.WithVectorClock())
+	}
+	if *queueSize > 0 {
+		policy, err := transport.ParseOverflowPolicy(*queuePolicy)
+		if err != nil {
+			log.Fatalf("Invalid -queue-policy: %v", err)
+		}
+		rogueOpts = append(rogueOpts, transport.WithDeliveryQueue(transport.QueueConfig{
+			Size:   *queueSize,
+			Policy: policy,
+			Shared: *queueShared,
+		}))
+	}
+	opts := append([]transport.Option(nil), rogueOpts...)
+	if *keysFile != "" {
+		keyring, err := transport.LoadKeyring(*keysFile)
+		if err != nil {
+			log.Fatalf("Invalid -keys: %v", err)
+		}
+		opts = append(opts, transport.WithKeyring(keyring))
 	}
+
+	netCfg := netConfig{kind: *network, natsURL: *natsURL, listen: *listen}
+	if *peers != "" {
+		netCfg.peers = strings.Split(*peers, ",")
+	}
+
+	// Control commands publish to the control subject and exit
+	if flag.NArg() > 0 {
+		runControl(netCfg, flag.Args(), *speed, opts)
+		return
+	}
+
+	// The local role runs every node in this process on an in-memory bus
+	if *role == "local" {
+		runLocal(strings.Split(*trucks, ","), *rogue, *run, *faultSeed, faults, causal, ordering, *checkpoint, trace, opts, rogueOpts, newClock, hybrid)
+		return
+	}
+
+	// A rogue node never holds the secret of the node it impersonates
+	if *role == "rogue" {
+		opts = rogueOpts
+	}
+
+	// Connect to NATS or the peer mesh
+	nt, err := connect(*id, netCfg, opts)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	nt = withTrace(nt, trace)
+	nt.SetClock(newClock())
+	t := withSnapshots(withTotalOrder(withCausal(withFaults(nt, *faultSeed, faults), causal), ordering))
 	defer t.Close()
+	if *checkpoint > 0 {
+		go checkpoints(t, *checkpoint)
+	}
+	go announceRun(t, *run, *role)
+	if *role != "observer" {
+		go reportStats(t, 10*time.Second)
+	}
+	if *queueSize > 0 {
+		go reportQueues(t, 30*time.Second)
+	}
 
 	// fmt.Printf("\n╔═══════════════════════════════════════════════════╗\n")
 	// fmt.Printf("║  Fire Truck System                                  ║\n")
@@ -38,19 +182,441 @@ func main() {
 	// Launch appropriate role
 	switch *role {
 	case "truck":
-		runFireTruck(t, *id)
+		runFireTruck(t, *id, newClock)
 	case "observer":
-		runObserver(t, *id)
+		runObserver(t, *id, hybrid)
+	case "water-supply":
+		runWaterSupply(t, *id)
+	case "rogue":
+		runRogue(t, *id)
+	case "recorder":
+		runRecorder(t, *recordFile)
+	default:
+		log.Fatalf("Unknown role: %s. Valid roles: truck, observer, water-supply, rogue, recorder, local", *role)
+	}
+}
+
+// idSet returns the set of the given node IDs
+func idSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[strings.TrimSpace(id)] = true
+	}
+	return set
+}
+
+// reportQueues periodically prints the depth of the node's delivery queues
+func reportQueues(t transport.Transport, interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, q := range t.QueueStats() {
+			fmt.Printf("[%s] queue %s: depth %d/%d (max %d), delivered %d, dropped %d\n",
+				t.GetID(), q.Name, q.Depth, q.Capacity, q.MaxDepth, q.Delivered, q.Dropped)
+		}
+	}
+}
+
+// runLocal runs the trucks and an observer in one process without NATS
+func runLocal(truckIDs []string, rogueID, run string, seed int64, faults map[string]transport.FaultConfig, causal []string, ordering ordering, checkpoint time.Duration, trace *record.Recorder, opts, rogueOpts []transport.Option, newClock func() clock.Clock, hybrid bool) {
+	bus := transport.NewMemBus()
+	connect := func(id, role string, opts []transport.Option) transport.Transport {
+		t := withTrace(transport.NewMemTransport(id, bus, opts...), trace)
+		t.SetClock(newClock())
+		go announceRun(t, run, role)
+		return t
+	}
+
+	water := withSnapshots(withTotalOrder(withCausal(connect("WATER-SUPPLY", "water-supply", opts), causal), ordering))
+	go reportStats(water, 10*time.Second)
+	go runWaterSupply(water, "WATER-SUPPLY")
+	for i, id := range truckIDs {
+		// Offset the seed so nodes do not see identical fault patterns
+		t := withSnapshots(withTotalOrder(withCausal(withFaults(connect(id, "truck", opts), seed+int64(i), faults), causal), ordering))
+		go reportStats(t, 10*time.Second)
+		go runFireTruck(t, id, newClock)
+	}
+	if rogueID != "" {
+		go runRogue(connect(rogueID, "rogue", rogueOpts), rogueID)
+	}
+	go readControl(withSnapshots(transport.NewMemTransport("CONTROL", bus, opts...)))
+	observer := withSnapshots(withTotalOrder(withCausal(connect("OBSERVER", "observer", opts), causal), ordering))
+	if checkpoint > 0 {
+		go checkpoints(observer, checkpoint)
+	}
+	runObserver(observer, "OBSERVER", hybrid)
+}
+
+// announceRun periodically tells every observer on the broker which run
+// this node belongs to
+func announceRun(t transport.Transport, run, role string) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		msg, err := message.New(message.TypeRunAnnounce, t.GetID(), message.RunAnnounce{Run: run, Role: role})
+		if err != nil {
+			log.Printf("Failed to announce run: %v", err)
+			return
+		}
+		if err := t.Publish(transport.ChannelRuns, msg); err != nil {
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// reportStats periodically tells the observer how much this node sent and
+// which messages it lost or received twice, per peer and channel
+func reportStats(t transport.Transport, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var streams []message.StreamStats
+		for _, s := range t.SeqStats() {
+			if s.Gaps == 0 && s.Duplicates == 0 {
+				continue
+			}
+			streams = append(streams, message.StreamStats{
+				Peer:       s.Peer,
+				Channel:    s.Channel,
+				Received:   s.Received,
+				Duplicates: s.Duplicates,
+				Gaps:       s.Gaps,
+				Late:       s.Late,
+			})
+		}
+		total := t.Stats().Totals()
+		msg, err := message.New(message.TypeStatsReport, t.GetID(), message.StatsReport{
+			Streams:       streams,
+			Sent:          total.Sent,
+			BytesSent:     total.BytesSent,
+			HandlerErrors: total.HandlerErrors,
+			DecodeErrors:  total.DecodeErrors,
+			Invalid:       total.Invalid,
+			Expired:       total.Expired,
+		})
+		if err != nil {
+			log.Printf("Failed to report stats: %v", err)
+			return
+		}
+		if err := t.Publish(transport.ChannelStatsReport, msg); err != nil {
+			return
+		}
+	}
+}
+
+// clockFactory returns a constructor for the clock named on the command line
+func clockFactory(kind string) (func() clock.Clock, error) {
+	switch kind {
+	case "lamport":
+		return func() clock.Clock { return clock.NewLamportClock() }, nil
+	case "hlc":
+		return func() clock.Clock { return clock.NewHybridClock() }, nil
+	default:
+		return nil, fmt.Errorf("unknown clock %q. Valid clocks: lamport, hlc", kind)
+	}
+}
+
+// netConfig selects the network transport for a node
+type netConfig struct {
+	kind    string // nats or mesh
+	natsURL string
+	listen  string
+	peers   []string
+}
+
+// connect joins the network chosen on the command line
+func connect(id string, cfg netConfig, opts []transport.Option) (transport.Transport, error) {
+	switch cfg.kind {
+	case "nats":
+		t, err := transport.NewNATSTransport(id, cfg.natsURL, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case "mesh":
+		t, err := transport.NewMeshTransport(id, transport.MeshConfig{Listen: cfg.listen, Peers: cfg.peers}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Mesh node %s listening on %s", id, t.Addr())
+		return t, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q. Valid transports: nats, mesh", cfg.kind)
+	}
+}
+
+// runControl sends a one-off control command such as a partition change
+func runControl(cfg netConfig, args []string, speed float64, opts []transport.Option) {
+	// On the mesh, join on any free port and give the mesh time to form
+	if cfg.kind == "mesh" {
+		cfg.listen = "127.0.0.1:0"
+	}
+	nt, err := connect("CONTROL", cfg, opts)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	t := withSnapshots(nt)
+	defer t.Close()
+	if mesh, ok := nt.(*transport.MeshTransport); ok {
+		waitForMesh(mesh, 5*time.Second)
+	}
+
+	if args[0] == "replay" {
+		if len(args) != 2 {
+			log.Fatalf("Usage: distributed [flags] replay <file>")
+		}
+		replay(t, args[1], speed)
+		return
+	}
+	if err := sendControl(t, args); err != nil {
+		log.Fatalf("Control command failed: %v", err)
+	}
+}
+
+// replay publishes a recording back into the network
+func replay(t transport.Transport, path string, speed float64) {
+	entries, err := record.Load(path)
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+	log.Printf("Replaying %d messages from %s (speed %g)", len(entries), path, speed)
+	n, err := record.Replay(t, entries, speed)
+	if err != nil {
+		log.Fatalf("Replay failed after %d messages: %v", n, err)
+	}
+	log.Printf("Replayed %d messages", n)
+}
+
+// waitForMesh waits until the node has met its peers and learned what
+// they subscribe to
+func waitForMesh(t *transport.MeshTransport, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for len(t.Peers()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	// Allow peer gossip to connect the rest of the mesh
+	time.Sleep(2 * time.Second)
+}
+
+// readControl applies control commands typed on stdin, one per line
+func readControl(t transport.Transport) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if err := sendControl(t, args); err != nil {
+			log.Printf("Control command failed: %v", err)
+		}
+	}
+}
+
+// sendControl publishes the control message for a command
+func sendControl(t transport.Transport, args []string) error {
+	switch args[0] {
+	case "partition":
+		spec := strings.Join(args[1:], " ")
+		groups, err := transport.ParsePartition(spec)
+		if err != nil {
+			return err
+		}
+		msg, err := transport.PartitionMessage(spec)
+		if err != nil {
+			return err
+		}
+		log.Printf("Installing partition %s", transport.FormatPartition(groups))
+		return t.Publish(transport.ChannelControlPartition, msg)
+	case "heal":
+		msg, err := transport.PartitionMessage("")
+		if err != nil {
+			return err
+		}
+		log.Printf("Healing partition")
+		return t.Publish(transport.ChannelControlPartition, msg)
+	case "snapshot":
+		path := fmt.Sprintf("snapshot-%s.json", time.Now().Format("20060102-150405"))
+		if len(args) > 1 {
+			path = args[1]
+		}
+		return takeSnapshot(t, path)
 	default:
-		log.Fatalf("Unknown role: %s. Valid roles: truck, observer", *role)
+		return fmt.Errorf("unknown command %q. Valid commands: partition, heal, snapshot", args[0])
+	}
+}
+
+// takeSnapshot records a global snapshot and writes it to path
+func takeSnapshot(t transport.Transport, path string) error {
+	st, ok := t.(*transport.SnapshotTransport)
+	if !ok {
+		return fmt.Errorf("snapshots are not enabled on %s", t.GetID())
+	}
+	snap, err := st.Snapshot()
+	if err != nil {
+		return fmt.Errorf("failed to take snapshot: %w", err)
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	inFlight := 0
+	var incomplete []string
+	for _, n := range snap.Nodes {
+		inFlight += len(n.InFlight)
+		if len(n.Missing) > 0 {
+			incomplete = append(incomplete, n.Node)
+		}
+	}
+	log.Printf("Snapshot %d: %d nodes, %d messages in flight, written to %s", snap.ID, len(snap.Nodes), inFlight, path)
+	if len(incomplete) > 0 {
+		log.Printf("Snapshot %d: markers missing at %v", snap.ID, incomplete)
+	}
+	return nil
+}
+
+// checkpoints periodically writes a global snapshot started by this node
+func checkpoints(t transport.Transport, interval time.Duration) {
+	for range time.Tick(interval) {
+		path := fmt.Sprintf("snapshot-%s.json", time.Now().Format("20060102-150405"))
+		if err := takeSnapshot(t, path); err != nil {
+			log.Printf("Checkpoint failed: %v", err)
+		}
+	}
+}
+
+// recordState sets what this node records in global snapshots
+func recordState(t transport.Transport, state transport.StateFunc) {
+	if st, ok := t.(*transport.SnapshotTransport); ok {
+		st.SetState(state)
+	}
+}
+
+// gridState lists the cells of a grid that are not empty, for snapshots
+func gridState(grid *simulation.Grid) []map[string]interface{} {
+	var cells []map[string]interface{}
+	for r := 0; r < simulation.GridSize; r++ {
+		for c := 0; c < simulation.GridSize; c++ {
+			cell := grid.GetCell(r, c)
+			if cell.State == simulation.Empty {
+				continue
+			}
+			cells = append(cells, map[string]interface{}{
+				"row":       r,
+				"col":       c,
+				"state":     cell.State.String(),
+				"intensity": cell.Intensity,
+			})
+		}
+	}
+	return cells
+}
+
+// runRecorder appends every message on every channel to a JSON-lines file
+func runRecorder(t transport.Transport, path string) {
+	rec, err := record.NewRecorder(path)
+	if err != nil {
+		log.Fatalf("Recorder failed: %v", err)
+	}
+
+	// Entries go straight to the file, so stopping the recorder loses nothing
+	if err := t.Subscribe(">", rec.Record); err != nil {
+		log.Fatalf("Recorder failed: %v", err)
+	}
+	log.Printf("Recording all traffic to %s", path)
+	select {}
+}
+
+// withFaults wraps t in a fault injector when any faults are configured
+func withFaults(t transport.Transport, seed int64, faults map[string]transport.FaultConfig) transport.Transport {
+	if len(faults) == 0 {
+		return t
+	}
+	log.Printf("Fault injection enabled for %s (seed=%d): %v", t.GetID(), seed, faults)
+	return transport.NewFaultyTransport(t, seed, faults)
+}
+
+// withTrace records the traffic of t to trace, if tracing is enabled
+func withTrace(t transport.Transport, trace *record.Recorder) transport.Transport {
+	if trace == nil {
+		return t
+	}
+	return record.NewTracer(t, trace)
+}
+
+// withSnapshots wraps t so it takes part in global snapshots
+func withSnapshots(t transport.Transport) transport.Transport {
+	st, err := transport.NewSnapshotTransport(t)
+	if err != nil {
+		log.Fatalf("Failed to enable snapshots for %s: %v", t.GetID(), err)
+	}
+	return st
+}
+
+// withCausal wraps t in a causal delivery layer when any channels are
+// configured, and periodically prints its buffer
+func withCausal(t transport.Transport, channels []string) transport.Transport {
+	if len(channels) == 0 {
+		return t
+	}
+	ct, err := transport.NewCausalTransport(t, channels)
+	if err != nil {
+		log.Fatalf("Failed to enable causal delivery for %s: %v", t.GetID(), err)
+	}
+	log.Printf("Causal delivery enabled for %s: %v", t.GetID(), channels)
+	go reportCausal(ct, 30*time.Second)
+	return ct
+}
+
+// reportCausal periodically prints the causal delivery buffer
+func reportCausal(ct *transport.CausalTransport, interval time.Duration) {
+	for range time.Tick(interval) {
+		s := ct.CausalStats()
+		fmt.Printf("[%s] causal buffer: %d waiting (max %d), %d delayed, %d forced\n",
+			ct.GetID(), s.Buffered, s.MaxBuffered, s.Delayed, s.Forced)
+	}
+}
+
+// ordering selects the channels delivered in total order and the node
+// that numbers them
+type ordering struct {
+	channels  []string
+	sequencer string
+}
+
+// withTotalOrder wraps t in a total-order delivery layer when any channels
+// are configured, and periodically prints its buffer
+func withTotalOrder(t transport.Transport, o ordering) transport.Transport {
+	if len(o.channels) == 0 {
+		return t
+	}
+	ot, err := transport.NewTotalOrderTransport(t, o.sequencer, o.channels)
+	if err != nil {
+		log.Fatalf("Failed to enable total order for %s: %v", t.GetID(), err)
+	}
+	log.Printf("Total order enabled for %s: %v (sequencer %s)", t.GetID(), o.channels, o.sequencer)
+	go reportOrder(ot, 30*time.Second)
+	return ot
+}
+
+// reportOrder periodically prints the total-order delivery buffer
+func reportOrder(ot *transport.TotalOrderTransport, interval time.Duration) {
+	for range time.Tick(interval) {
+		s := ot.OrderStats()
+		fmt.Printf("[%s] order buffer: %d waiting (max %d), %d sequenced, %d skipped, %d unordered\n",
+			ot.GetID(), s.Buffered, s.MaxBuffered, s.Sequenced, s.Skipped, s.Unordered)
 	}
 }
 
 // runFireTruck operates as an autonomous fire-fighting agent
-func runFireTruck(t *transport.NATSTransport, truckID string) {
+func runFireTruck(t transport.Transport, truckID string, newClock func() clock.Clock) {
 	// Initialize truck at starting position
 	row, col := simulation.GetStartingPosition(truckID, simulation.GridSize)
 	truck := simulation.NewFiretruck(truckID, row, col)
+	truck.Clock = newClock()
 	truck.SetTransport(t)
 
 	// Initialize Ricart-Agrawala for water
@@ -60,8 +626,9 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 	sharedClock := truck.Clock
 	t.SetClock(sharedClock)
 
-	// Initialize local grid simulation
+	// Initialize local grid simulation from whatever fires are already known
 	grid := simulation.NewGrid()
+	fetchState(t, truckID, grid)
 
 	// Track if currently assigned to a fire
 	var assignedMu sync.Mutex
@@ -76,16 +643,47 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 	bidsByFire := make(map[string][]message.Message)
 	timers := make(map[string]*time.Timer)
 
+	// Winner of each burning fire, from the broadcast decisions
+	var claimMu sync.Mutex
+	claimed := make(map[string]string)
+
+	// What this truck contributes to global snapshots
+	recordState(t, func() interface{} {
+		state := truck.SnapshotState()
+		state["grid"] = gridState(grid)
+		assignedMu.Lock()
+		if currentAssignment != nil {
+			state["assigned"] = fmt.Sprintf("%d,%d", currentAssignment.Row, currentAssignment.Col)
+		}
+		assignedMu.Unlock()
+		claimMu.Lock()
+		taken := make(map[string]interface{}, len(claimed))
+		for fire, winner := range claimed {
+			taken[fire] = winner
+		}
+		claimMu.Unlock()
+		state["claimed"] = taken
+		return state
+	})
+
 	log.Printf("Truck %s initialized at (%d,%d) with %d/%d water", truckID, row, col, truck.Water, truck.MaxWater)
 
 	// Broadcast initial status
 	truck.BroadcastStatus()
 
+	// Catch up on whatever happened while the connection was down
+	t.OnDisconnect(func(err error) {
+		log.Printf("Truck %s: disconnected: %v", truckID, err)
+	})
+	t.OnReconnect(func() {
+		log.Printf("Truck %s: reconnected, resyncing", truckID)
+		truck.BroadcastStatus()
+		truck.ReannounceWaterRA()
+		fetchState(t, truckID, grid)
+	})
+
 	// Subscribe to fire alerts and bid on fires
 	t.Subscribe(transport.ChannelFireAlerts, func(msg message.Message) error {
-		// Update Lamport clock on message receive
-		sharedClock.Receive(msg.Lamport)
-
 		// Check if already assigned
 		assignedMu.Lock()
 		if currentAssignment != nil {
@@ -94,20 +692,11 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 		}
 		assignedMu.Unlock()
 
-		// Handle both old FireAlert and new FireAnnounce formats
-
-		var fireRow, fireCol, intensity int
-		if row, ok := msg.Payload["row"].(float64); ok {
-			// Old format
-			fireRow = int(row)
-			fireCol = int(msg.Payload["col"].(float64))
-			intensity = int(msg.Payload["intensity"].(float64))
-		} else {
-			// New FireAnnounce format
-			fireRow = int(msg.Payload["id_x"].(float64))
-			fireCol = int(msg.Payload["id_y"].(float64))
-			intensity = int(msg.Payload["intensity"].(float64))
+		alert, err := message.Decode[message.FireAlert](msg)
+		if err != nil {
+			return err
 		}
+		fireRow, fireCol, intensity := alert.Row, alert.Col, alert.Intensity
 
 		log.Printf("Truck %s: Fire alert received at (%d,%d), intensity %d", truckID, fireRow, fireCol, intensity)
 
@@ -122,33 +711,41 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 		lastFireSeen = time.Now()
 		fireMu.Unlock()
 
+		// A fire someone already won needs no bids
+		claimMu.Lock()
+		winner, taken := claimed[fmt.Sprintf("%v,%v", fireRow, fireCol)]
+		claimMu.Unlock()
+		if taken {
+			log.Printf("Truck %s: fire (%d,%d) already handled by %s", truckID, fireRow, fireCol, winner)
+			return nil
+		}
+
 		// Bid if we have sufficient water to respond
 		if truck.GetWater() >= intensity {
 			distance := simulation.Abs(truck.Row-fireRow) + simulation.Abs(truck.Col-fireCol)
 			// Lower score equals lower distance to fire
 			score := distance
 
-			// Broadcast bid using new typed message
+			// Broadcast bid using new typed message. Its own timestamp
+			// breaks ties between equal scores; the message is stamped by
+			// the transport as it goes out
 			lamportTs := sharedClock.Tick()
 			bid := message.Bid{
-				Fire:    message.FireID{X: fireRow, Y: fireCol},
+				FireX:   fireRow,
+				FireY:   fireCol,
 				Bidder:  truckID,
 				Score:   score,
 				Lamport: int(lamportTs),
 			}
 
-			bidMsg := message.Message{
-				Type:    message.TypeBid,
-				From:    truckID,
-				Lamport: lamportTs,
-				Payload: map[string]interface{}{
-					"fire_x":  float64(fireRow),
-					"fire_y":  float64(fireCol),
-					"bidder":  bid.Bidder,
-					"score":   float64(bid.Score),
-					"lamport": float64(bid.Lamport),
-				},
+			bidMsg, err := message.New(message.TypeBid, truckID, bid)
+			if err != nil {
+				return err
 			}
+			// The bid belongs to the alert's incident; stamp it now so the
+			// copy kept below has the ID the others see
+			bidMsg.Follow(msg)
+			bidMsg.Stamp()
 			t.Publish(transport.ChannelFireBids, bidMsg)
 			log.Printf("Truck %s: bid fire=(%d,%d) score=%d ts=%d", truckID, fireRow, fireCol, score, bid.Lamport)
 
@@ -159,7 +756,7 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 
 			// Start timer if not already running for this fire
 			if timers[fireKey] == nil {
-				timers[fireKey] = time.AfterFunc(1*time.Second, func() {
+				timers[fireKey] = time.AfterFunc(bidWindow, func() {
 					mu.Lock()
 					bids := bidsByFire[fireKey]
 					delete(bidsByFire, fireKey)
@@ -184,13 +781,12 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 
 	// Collect bids from other trucks
 	t.Subscribe(transport.ChannelFireBids, func(msg message.Message) error {
-		// Update Lamport clock on message receive
-		sharedClock.Receive(msg.Lamport)
-
 		// Handle bid
-		fireX := int(msg.Payload["fire_x"].(float64))
-		fireY := int(msg.Payload["fire_y"].(float64))
-		fireKey := fmt.Sprintf("%v,%v", fireX, fireY)
+		bid, err := message.Decode[message.Bid](msg)
+		if err != nil {
+			return err
+		}
+		fireKey := fmt.Sprintf("%v,%v", bid.FireX, bid.FireY)
 
 		mu.Lock()
 		bidsByFire[fireKey] = append(bidsByFire[fireKey], msg)
@@ -199,43 +795,91 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 		return nil
 	})
 
-	// Subscribe to bid decisions
-	t.Subscribe(transport.ChannelFireDecision, func(msg message.Message) error {
-		// Update Lamport clock on message receive
-		sharedClock.Receive(msg.Lamport)
-
-		winner := msg.Payload["winner"].(string)
-		fireX := int(msg.Payload["fire_x"].(float64))
-		fireY := int(msg.Payload["fire_y"].(float64))
-
-		if winner == truckID {
-			log.Printf("Truck %s: Assigned to fire at (%d,%d)", truckID, fireX, fireY)
-
+	// Assignment requests and bid decisions are sent directly to each bidder
+	t.SubscribeInbox(func(msg message.Message) error {
+		switch msg.Type {
+		case message.TypeFireAssignment:
+			assignment, err := message.Decode[message.FireAssignment](msg)
+			if err != nil {
+				return err
+			}
+			fireX, fireY := assignment.FireRow, assignment.FireCol
 			fire := &simulation.FireLocation{Row: fireX, Col: fireY}
 
-			// Set assignment
+			// Accept only if not already busy with another fire
 			assignedMu.Lock()
-			currentAssignment = fire
+			accepted := currentAssignment == nil
+			if accepted {
+				currentAssignment = fire
+			}
 			assignedMu.Unlock()
 
-			// Process assignment in goroutine
-			go handleFireAssignment(t, truck, grid, fire, &assignedMu, &currentAssignment, sharedClock)
-		} else {
-			log.Printf("Truck %s: Assignment denied, winner is %s", truckID, winner)
+			ack, err := message.New(message.TypeAssignmentAck, truckID, message.AssignmentAck{
+				FireRow:  fireX,
+				FireCol:  fireY,
+				Accepted: accepted,
+			})
+			if err == nil {
+				err = t.Reply(msg, ack)
+			}
+			if err != nil {
+				if accepted {
+					assignedMu.Lock()
+					currentAssignment = nil
+					assignedMu.Unlock()
+				}
+				return fmt.Errorf("failed to acknowledge assignment: %w", err)
+			}
+
+			if accepted {
+				log.Printf("Truck %s: Assigned to fire at (%d,%d)", truckID, fireX, fireY)
+
+				// Process assignment in goroutine
+				go handleFireAssignment(t, truck, grid, fire, &assignedMu, &currentAssignment, sharedClock, msg)
+			} else {
+				log.Printf("Truck %s: Busy, declined fire at (%d,%d)", truckID, fireX, fireY)
+			}
+
+		case message.TypeBidDecision:
+			decision, err := message.Decode[message.BidDecision](msg)
+			if err != nil {
+				return err
+			}
+			if decision.Winner != truckID {
+				log.Printf("Truck %s: Assignment denied, winner is %s", truckID, decision.Winner)
+			}
 		}
 
 		return nil
 	})
 
+	// Track which truck handles each fire
+	t.Subscribe(transport.ChannelFireDecision, func(msg message.Message) error {
+		decision, err := message.Decode[message.BidDecision](msg)
+		if err != nil {
+			return err
+		}
+		if decision.Winner != "" {
+			claimMu.Lock()
+			claimed[fmt.Sprintf("%v,%v", decision.FireX, decision.FireY)] = decision.Winner
+			claimMu.Unlock()
+		}
+		return nil
+	})
+
 	// Subscribe to extinguish events to update local grid
 	t.Subscribe(transport.ChannelCoordination, func(msg message.Message) error {
-		// Update Lamport clock on message receive
-		sharedClock.Receive(msg.Lamport)
-
-		if action, ok := msg.Payload["action"].(string); ok && action == "extinguished" {
-			row := int(msg.Payload["target_row"].(float64))
-			col := int(msg.Payload["target_col"].(float64))
+		c, err := message.Decode[message.Coordination](msg)
+		if err != nil {
+			return err
+		}
+		if c.Action == "extinguished" {
+			row, col := c.TargetRow, c.TargetCol
 			grid.SetCell(row, col, simulation.Cell{State: simulation.Extinguished})
+
+			claimMu.Lock()
+			delete(claimed, fmt.Sprintf("%v,%v", row, col))
+			claimMu.Unlock()
 		}
 		return nil
 	})
@@ -268,12 +912,15 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 
 				// fire intensity increases exponentially
 				intensity := 2 + randSrc.Intn(3) // intensity 2-4
-				msg := message.NewMessage(
-					message.TypeFireAlert,
-					truckID,
-					message.FireAlertPayload(row, col, intensity),
-				)
-				msg.Lamport = sharedClock.Tick()
+				msg, err := message.New(message.TypeFireAlert, truckID, message.FireAlert{
+					Row:       row,
+					Col:       col,
+					Intensity: intensity,
+				})
+				if err != nil {
+					log.Printf("Truck %s: failed to announce fire: %v", truckID, err)
+					continue
+				}
 				t.Publish(transport.ChannelFireAlerts, msg)
 				log.Printf("Truck %s: Generated fire at (%d,%d), intensity %d", truckID, row, col, intensity)
 				fireMu.Lock()
@@ -296,27 +943,30 @@ func runFireTruck(t *transport.NATSTransport, truckID string) {
 }
 
 // Processes collected bids and announces winner
-func evaluateAndAnnounce(t *transport.NATSTransport, truck *simulation.Firetruck, truckID string, bids []message.Message, clock *clock.LamportClock) {
+func evaluateAndAnnounce(t transport.Transport, truck *simulation.Firetruck, truckID string, bids []message.Message, clock clock.Clock) {
 	if len(bids) == 0 {
 		return
 	}
 
-	// Extract fire location
-	fireX := int(bids[0].Payload["fire_x"].(float64))
-	fireY := int(bids[0].Payload["fire_y"].(float64))
-
-	log.Printf("Truck %s: Evaluating %d bids for fire=(%d,%d)", truckID, len(bids), fireX, fireY)
-
-	// Convert to typed bids for sorting
+	// Convert to typed bids for sorting; malformed bids were rejected on
+	// receipt
 	var typedBids []message.Bid
 	for _, b := range bids {
-		typedBids = append(typedBids, message.Bid{
-			Fire:    message.FireID{X: fireX, Y: fireY},
-			Bidder:  b.Payload["bidder"].(string),
-			Score:   int(b.Payload["score"].(float64)),
-			Lamport: int(b.Payload["lamport"].(float64)),
-		})
+		bid, err := message.Decode[message.Bid](b)
+		if err != nil {
+			log.Printf("Truck %s: %v", truckID, err)
+			continue
+		}
+		typedBids = append(typedBids, bid)
 	}
+	if len(typedBids) == 0 {
+		return
+	}
+
+	// Extract fire location
+	fireX, fireY := typedBids[0].FireX, typedBids[0].FireY
+
+	log.Printf("Truck %s: Evaluating %d bids for fire=(%d,%d)", truckID, len(typedBids), fireX, fireY)
 
 	// Sort bids with proper tie-breaking: Score ASC, Lamport ASC, Bidder ASC
 	sort.Slice(typedBids, func(i, j int) bool {
@@ -344,27 +994,153 @@ func evaluateAndAnnounce(t *transport.NATSTransport, truck *simulation.Firetruck
 
 	// Only the lowest truck ID announces to prevent duplicates
 	if truckID == announcer {
-		decision := message.Message{
-			Type:    message.TypeBidDecision,
-			From:    truckID,
-			Lamport: clock.Tick(),
-			Payload: map[string]interface{}{
-				"fire_x":  fireX,
-				"fire_y":  fireY,
-				"winner":  winner,
-				"lamport": clock.Now(),
-			},
-		}
-		t.Publish(transport.ChannelFireDecision, decision)
-		log.Printf("Truck %s: DECISION fire=(%d,%d) winner=%s by (score,ts,id)", truckID, fireX, fireY, winner)
+		// The winner must acknowledge; otherwise the next best bidder is asked
+		winner = assignFire(t, truckID, typedBids, fireX, fireY, bids[0])
+
+		lamportTs := clock.Tick()
+		decision, err := message.New(message.TypeBidDecision, truckID, message.BidDecision{
+			FireX:   fireX,
+			FireY:   fireY,
+			Winner:  winner,
+			Lamport: int(lamportTs),
+		})
+		if err != nil {
+			log.Printf("Truck %s: failed to announce decision: %v", truckID, err)
+			return
+		}
+		decision.Follow(bids[0])
+
+		// Tell every bidder, once each, who won
+		notified := make(map[string]bool)
+		for _, b := range typedBids {
+			if notified[b.Bidder] {
+				continue
+			}
+			notified[b.Bidder] = true
+			if err := t.Send(b.Bidder, decision); err != nil {
+				log.Printf("Truck %s: failed to send decision to %s: %v", truckID, b.Bidder, err)
+			}
+		}
+
+		// Let every node record who handles the fire
+		if winner != "" {
+			if err := t.Publish(transport.ChannelFireDecision, decision); err != nil {
+				log.Printf("Truck %s: failed to broadcast decision: %v", truckID, err)
+			}
+		}
+		log.Printf("Truck %s: DECISION fire=(%d,%d) winner=%s by (score,ts,id) incident=%s", truckID, fireX, fireY, winner, decision.Correlation)
 	} else {
 		log.Printf("Truck %s: Assignment deferred, announcer is %s", truckID, announcer)
 	}
 }
 
-// Moves truck to fire and extinguishes it
-func handleFireAssignment(t *transport.NATSTransport, truck *simulation.Firetruck,
-	grid *simulation.Grid, fire *simulation.FireLocation, assignedMu *sync.Mutex, currentAssignment **simulation.FireLocation, clock *clock.LamportClock) {
+// Offers the fire to bidders in ranked order until one acknowledges, as
+// part of the incident of cause. Returns the accepting truck, or "" if
+// nobody accepted in time.
+func assignFire(t transport.Transport, truckID string, ranked []message.Bid, fireX, fireY int, cause message.Message) string {
+	asked := make(map[string]bool)
+	for _, b := range ranked {
+		if asked[b.Bidder] {
+			continue
+		}
+		asked[b.Bidder] = true
+
+		req, err := message.New(message.TypeFireAssignment, truckID, message.FireAssignment{
+			FireRow:       fireX,
+			FireCol:       fireY,
+			AssignedTruck: b.Bidder,
+			Reason:        "lowest score",
+		})
+		if err != nil {
+			log.Printf("Truck %s: failed to offer fire=(%d,%d): %v", truckID, fireX, fireY, err)
+			return ""
+		}
+		req.Follow(cause)
+		resp, err := t.Request(transport.InboxChannel(b.Bidder), req, assignAckTimeout)
+		if err != nil {
+			log.Printf("Truck %s: no ack from %s for fire=(%d,%d): %v", truckID, b.Bidder, fireX, fireY, err)
+			continue
+		}
+		ack, err := message.Decode[message.AssignmentAck](resp)
+		if err != nil {
+			log.Printf("Truck %s: %v", truckID, err)
+			continue
+		}
+		if ack.Accepted {
+			return b.Bidder
+		}
+		log.Printf("Truck %s: %s declined fire=(%d,%d)", truckID, b.Bidder, fireX, fireY)
+	}
+	return ""
+}
+
+// Seeds the local grid from a state snapshot, if any node answers
+func fetchState(t transport.Transport, truckID string, grid *simulation.Grid) {
+	query, err := message.New(message.TypeStateQuery, truckID, message.StateQuery{})
+	if err != nil {
+		log.Printf("Truck %s: failed to query state: %v", truckID, err)
+		return
+	}
+	resp, err := t.Request(transport.ChannelStateQuery, query, stateQueryTimeout)
+	if err != nil {
+		log.Printf("Truck %s: no state snapshot available: %v", truckID, err)
+		return
+	}
+
+	// Fires we know of but the snapshot lacks went out while we weren't looking
+	stale := make(map[[2]int]bool)
+	for _, f := range grid.FindAllFires() {
+		stale[[2]int{f.Row, f.Col}] = true
+	}
+
+	snapshot, err := message.Decode[message.StateSnapshot](resp)
+	if err != nil {
+		log.Printf("Truck %s: %v", truckID, err)
+		return
+	}
+	fires := snapshot.Fires
+	for _, f := range fires {
+		grid.SetCell(f.Row, f.Col, simulation.Cell{
+			State:     simulation.Fire,
+			Intensity: f.Intensity,
+		})
+		delete(stale, [2]int{f.Row, f.Col})
+	}
+	for pos := range stale {
+		grid.SetCell(pos[0], pos[1], simulation.Cell{State: simulation.Extinguished})
+	}
+	log.Printf("Truck %s: loaded %d known fires from %s", truckID, len(fires), resp.From)
+}
+
+// Grants water to trucks refilling inside the RA critical section
+func runWaterSupply(t transport.Transport, supplyID string) {
+	log.Printf("Water supply %s ready", supplyID)
+
+	t.Subscribe(transport.ChannelWaterSupply, func(msg message.Message) error {
+		req, err := message.Decode[message.WaterRequest](msg)
+		if err != nil {
+			return err
+		}
+		amount := max(req.Amount, 0)
+
+		resp, err := message.New(message.TypeWaterResponse, supplyID, message.WaterResponse{Amount: amount})
+		if err != nil {
+			return err
+		}
+		if err := t.Reply(msg, resp); err != nil {
+			return err
+		}
+		log.Printf("Water supply: granted %d water to %s", amount, msg.From)
+		return nil
+	})
+
+	select {}
+}
+
+// Moves truck to fire and extinguishes it. The extinguish event follows the
+// assignment, closing its incident.
+func handleFireAssignment(t transport.Transport, truck *simulation.Firetruck,
+	grid *simulation.Grid, fire *simulation.FireLocation, assignedMu *sync.Mutex, currentAssignment **simulation.FireLocation, clock clock.Clock, assignment message.Message) {
 
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
@@ -385,18 +1161,22 @@ func handleFireAssignment(t *transport.NATSTransport, truck *simulation.Firetruc
 				log.Printf("   Water remaining: %d/%d units", truck.GetWater(), truck.MaxWater)
 				log.Printf("   Extinguished by: Truck %s", truck.ID)
 				log.Printf("   Lamport timestamp: %d", clock.Tick())
+				log.Printf("   Incident: %s", assignment.Correlation)
 				log.Printf("   Broadcasting extinguish event to all trucks...")
 
 				// Broadcast extinguish event
-				msg := message.NewMessage(
-					message.TypeCoordination,
-					truck.ID,
-					message.CoordinationPayload("extinguished", row, col, map[string]interface{}{
-						"water_used": used,
-					}),
-				)
-				msg.Lamport = clock.Tick()
-				t.Publish(transport.ChannelCoordination, msg)
+				msg, err := message.New(message.TypeCoordination, truck.ID, message.Coordination{
+					Action:    "extinguished",
+					TargetRow: row,
+					TargetCol: col,
+					WaterUsed: used,
+				})
+				if err != nil {
+					log.Printf("[%s] failed to announce extinguish: %v", truck.ID, err)
+				} else {
+					msg.Follow(assignment)
+					t.Publish(transport.ChannelCoordination, msg)
+				}
 				truck.BroadcastStatus()
 			} else if cell.State != simulation.Fire {
 				log.Printf("[%s] Fire at (%d,%d) already extinguished", truck.ID, row, col)
@@ -432,8 +1212,53 @@ func handleFireAssignment(t *transport.NATSTransport, truck *simulation.Firetruc
 	}
 }
 
+// Impersonates another node without holding its key: claims every fire
+// with an unbeatable bid and grants forged RA replies to every truck.
+// Nodes started with -keys drop all of it.
+func runRogue(t transport.Transport, victimID string) {
+	var mu sync.Mutex
+	peers := make(map[string]bool)
+
+	t.Subscribe(transport.ChannelTruckStatus, func(msg message.Message) error {
+		if msg.From != victimID {
+			mu.Lock()
+			peers[msg.From] = true
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	log.Printf("ROGUE: impersonating %s", victimID)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		fireX, fireY := rand.Intn(simulation.GridSize), rand.Intn(simulation.GridSize)
+		bid, err := message.New(message.TypeBid, "", message.Bid{FireX: fireX, FireY: fireY, Bidder: victimID})
+		if err != nil {
+			continue
+		}
+		t.Publish(transport.ChannelFireBids, bid)
+
+		mu.Lock()
+		targets := make([]string, 0, len(peers))
+		for peer := range peers {
+			targets = append(targets, peer)
+		}
+		mu.Unlock()
+
+		for _, peer := range targets {
+			t.Send(peer, message.Message{Type: message.TypeWaterReply})
+		}
+		log.Printf("ROGUE: forged bid for (%d,%d) and %d water replies as %s", fireX, fireY, len(targets), victimID)
+	}
+}
+
 // Monitors and visualizes the system state
-func runObserver(t *transport.NATSTransport, observerID string) {
+func runObserver(t transport.Transport, observerID string, hybrid bool) {
+	// viewMu guards the observer's view of the grid and trucks, which the
+	// handlers, the fire loop, the display and snapshots all share
+	var viewMu sync.Mutex
 	grid := simulation.NewGrid()
 	trucks := make(map[string]*simulation.Firetruck)
 
@@ -447,38 +1272,62 @@ func runObserver(t *transport.NATSTransport, observerID string) {
 	log.Printf("Lamport clocks: Synchronized across all processes")
 	log.Printf("==================================================================================\n")
 
+	// The observer's view of the grid and trucks goes into global snapshots
+	recordState(t, func() interface{} {
+		viewMu.Lock()
+		defer viewMu.Unlock()
+		seen := make(map[string]interface{}, len(trucks))
+		for id, truck := range trucks {
+			seen[id] = map[string]interface{}{
+				"row":   truck.Row,
+				"col":   truck.Col,
+				"water": truck.Water,
+			}
+		}
+		return map[string]interface{}{
+			"grid":   gridState(grid),
+			"trucks": seen,
+		}
+	})
+
+	// Timestamp of the first alert for each fire, for response times
+	var alertMu sync.Mutex
+	alertedAt := make(map[[2]int]int64)
+
 	// Subscribe to all events
 	t.Subscribe(transport.ChannelFireAlerts, func(msg message.Message) error {
-		// Handle both old and new fire alert formats
-		var row, col, intensity int
-		if r, ok := msg.Payload["row"].(float64); ok {
-			// Old format
-			row = int(r)
-			col = int(msg.Payload["col"].(float64))
-			intensity = int(msg.Payload["intensity"].(float64))
-		} else {
-			// New FireAnnounce format
-			row = int(msg.Payload["id_x"].(float64))
-			col = int(msg.Payload["id_y"].(float64))
-			intensity = int(msg.Payload["intensity"].(float64))
+		alert, err := message.Decode[message.FireAlert](msg)
+		if err != nil {
+			return err
 		}
+		row, col, intensity := alert.Row, alert.Col, alert.Intensity
 
+		viewMu.Lock()
 		grid.SetCell(row, col, simulation.Cell{
 			State:     simulation.Fire,
 			Intensity: intensity,
 		})
+		viewMu.Unlock()
+		alertMu.Lock()
+		if _, ok := alertedAt[[2]int{row, col}]; !ok {
+			alertedAt[[2]int{row, col}] = msg.Lamport
+		}
+		alertMu.Unlock()
 
-		fmt.Printf("\nNEW FIRE DETECTED: (%d,%d) | Intensity: %d | Lamport: %d\n", row, col, intensity, msg.Lamport)
+		fmt.Printf("\nNEW FIRE DETECTED: (%d,%d) | Intensity: %d | Lamport: %d | Incident: %s\n", row, col, intensity, msg.Lamport, msg.Correlation)
 		return nil
 	})
 
 	t.Subscribe(transport.ChannelTruckStatus, func(msg message.Message) error {
 		truckID := msg.From
-		row := int(msg.Payload["row"].(float64))
-		col := int(msg.Payload["col"].(float64))
-		water := int(msg.Payload["water"].(float64))
-		maxWater := int(msg.Payload["max_water"].(float64))
+		status, err := message.Decode[message.TruckStatus](msg)
+		if err != nil {
+			return err
+		}
+		row, col := status.Row, status.Col
+		water, maxWater := status.Water, status.MaxWater
 
+		viewMu.Lock()
 		if trucks[truckID] == nil {
 			trucks[truckID] = simulation.NewFiretruck(truckID, row, col)
 		}
@@ -486,17 +1335,54 @@ func runObserver(t *transport.NATSTransport, observerID string) {
 		trucks[truckID].Col = col
 		trucks[truckID].Water = water
 		trucks[truckID].MaxWater = maxWater
+		viewMu.Unlock()
 
 		return nil
 	})
 
+	// Answer state snapshot requests with the fires currently known
+	t.Subscribe(transport.ChannelStateQuery, func(msg message.Message) error {
+		var fires []message.FireAlert
+		viewMu.Lock()
+		for _, f := range grid.FindAllFires() {
+			fires = append(fires, message.FireAlert{
+				Row:       f.Row,
+				Col:       f.Col,
+				Intensity: f.Intensity,
+			})
+		}
+		viewMu.Unlock()
+		resp, err := message.New(message.TypeStateSnapshot, observerID, message.StateSnapshot{Fires: fires})
+		if err != nil {
+			return err
+		}
+		return t.Reply(msg, resp)
+	})
+
+	var extinguished atomic.Uint64
 	t.Subscribe(transport.ChannelCoordination, func(msg message.Message) error {
-		if action, ok := msg.Payload["action"].(string); ok && action == "extinguished" {
-			row := int(msg.Payload["target_row"].(float64))
-			col := int(msg.Payload["target_col"].(float64))
+		c, err := message.Decode[message.Coordination](msg)
+		if err != nil {
+			return err
+		}
+		if c.Action == "extinguished" {
+			row, col := c.TargetRow, c.TargetCol
 
+			viewMu.Lock()
 			grid.SetCell(row, col, simulation.Cell{State: simulation.Extinguished})
-			fmt.Printf("\nFIRE EXTINGUISHED: (%d,%d) | By: Truck %s | Lamport: %d\n", row, col, msg.From, msg.Lamport)
+			viewMu.Unlock()
+			extinguished.Add(1)
+			fmt.Printf("\nFIRE EXTINGUISHED: (%d,%d) | By: Truck %s | Lamport: %d | Incident: %s\n", row, col, msg.From, msg.Lamport, msg.Correlation)
+
+			// Hybrid timestamps from different trucks are comparable in real time
+			alertMu.Lock()
+			alerted, ok := alertedAt[[2]int{row, col}]
+			delete(alertedAt, [2]int{row, col})
+			alertMu.Unlock()
+			if hybrid && ok {
+				response := clock.HybridTime(msg.Lamport).Sub(clock.HybridTime(alerted))
+				fmt.Printf("   Response time: %v (alert at %s)\n", response.Round(time.Millisecond), clock.HybridTime(alerted).Format("15:04:05.000"))
+			}
 		}
 		return nil
 	})
@@ -506,34 +1392,223 @@ func runObserver(t *transport.NATSTransport, observerID string) {
 		growthTicker := time.NewTicker(5 * time.Second)
 		defer growthTicker.Stop()
 		for range growthTicker.C {
+			viewMu.Lock()
 			newFires := grid.StepFires()
+			for i, fire := range newFires {
+				newFires[i].Intensity = grid.GetCell(fire.Row, fire.Col).Intensity
+			}
+			viewMu.Unlock()
 
 			// Publish alerts for newly spread fires
 			for _, fire := range newFires {
-				cell := grid.GetCell(fire.Row, fire.Col)
-				alert := message.Message{
-					Type:    message.TypeFireAnnounce,
-					From:    observerID,
-					Lamport: 1, // Observer timestamp
-					Payload: map[string]interface{}{
-						"id_x":      float64(fire.Row),
-						"id_y":      float64(fire.Col),
-						"intensity": float64(cell.Intensity),
-						"tick":      float64(0),
-					},
+				alert, err := message.New(message.TypeFireAlert, observerID, message.FireAlert{
+					Row:       fire.Row,
+					Col:       fire.Col,
+					Intensity: fire.Intensity,
+				})
+				if err != nil {
+					log.Printf("Observer: failed to announce fire: %v", err)
+					continue
 				}
 				t.Publish(transport.ChannelFireAlerts, alert)
 			}
 		}
 	}()
 
+	// Track every run sharing the broker and the nodes seen in each
+	var runsMu sync.Mutex
+	runs := make(map[string]map[string]time.Time)
+	t.Subscribe(transport.ChannelRuns, func(msg message.Message) error {
+		announce, err := message.Decode[message.RunAnnounce](msg)
+		if err != nil {
+			return err
+		}
+		run := announce.Run
+		runsMu.Lock()
+		if runs[run] == nil {
+			runs[run] = make(map[string]time.Time)
+		}
+		runs[run][msg.From] = time.Now()
+		runsMu.Unlock()
+		return nil
+	})
+
+	// Tap the coordination traffic between trucks, including their inboxes
+	tapped := map[string]bool{
+		message.TypeBid:           true,
+		message.TypeBidDecision:   true,
+		message.TypeAssignmentAck: true,
+		message.TypeWaterReq:      true,
+		message.TypeWaterReply:    true,
+		message.TypeWaterRelease:  true,
+	}
+	// With vector clocks, bids and decisions on the same fire made without
+	// knowledge of each other are flagged as concurrent
+	lastByFire := make(map[string]message.Message)
+	t.Subscribe(">", func(msg message.Message) error {
+		if !tapped[msg.Type] {
+			return nil
+		}
+		note := ""
+		if (msg.Type == message.TypeBid || msg.Type == message.TypeBidDecision) && msg.Vector != nil {
+			// Bids and decisions both name the fire in fire_x and fire_y
+			fire, err := message.Decode[message.BidDecision](msg)
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("%s:%v,%v", msg.Type, fire.FireX, fire.FireY)
+			prev, ok := lastByFire[key]
+			if ok && prev.From != msg.From && msg.Vector.Compare(prev.Vector) == clock.Concurrent {
+				note = " | concurrent with " + prev.From
+			}
+			lastByFire[key] = msg
+		}
+		fmt.Printf("  [%s] %s from %s | Lamport: %d%s\n", msg.Channel, msg.Type, msg.From, msg.Lamport, note)
+		return nil
+	})
+
+	// Messages any node rejected as invalid
+	t.Subscribe(transport.ChannelDeadLetter, func(msg message.Message) error {
+		letter, err := message.Decode[message.DeadLetter](msg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nDEAD LETTER: %s from %s on %s | Rejected by: %s | %s\n",
+			letter.Message.Type, letter.Message.From, letter.Channel, msg.From, letter.Reason)
+		return nil
+	})
+
+	// Latest traffic and loss report from every node
+	var lossMu sync.Mutex
+	losses := make(map[string][]transport.SeqStats)
+	traffic := make(map[string]transport.ChannelStats)
+	t.Subscribe(transport.ChannelStatsReport, func(msg message.Message) error {
+		report, err := message.Decode[message.StatsReport](msg)
+		if err != nil {
+			return err
+		}
+		var streams []transport.SeqStats
+		for _, s := range report.Streams {
+			streams = append(streams, transport.SeqStats{
+				Peer:       s.Peer,
+				Channel:    s.Channel,
+				Received:   s.Received,
+				Duplicates: s.Duplicates,
+				Gaps:       s.Gaps,
+				Late:       s.Late,
+			})
+		}
+
+		lossMu.Lock()
+		losses[msg.From] = streams
+		traffic[msg.From] = transport.ChannelStats{
+			Sent:          report.Sent,
+			BytesSent:     report.BytesSent,
+			HandlerErrors: report.HandlerErrors,
+			DecodeErrors:  report.DecodeErrors,
+			Invalid:       report.Invalid,
+			Expired:       report.Expired,
+		}
+		lossMu.Unlock()
+		return nil
+	})
+
 	// Periodic status display
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
 		fmt.Println("\n" + "═══════════════════════════════════════════════════")
+		viewMu.Lock()
 		printSystemState(grid, trucks)
+		viewMu.Unlock()
+		runsMu.Lock()
+		printRuns(runs)
+		runsMu.Unlock()
+		lossMu.Lock()
+		losses[observerID] = t.SeqStats()
+		traffic[observerID] = t.Stats().Totals()
+		printTraffic(traffic, extinguished.Load())
+		printLosses(losses)
+		lossMu.Unlock()
+	}
+}
+
+// Shows how many messages each node sent and the cost per extinguished fire
+func printTraffic(traffic map[string]transport.ChannelStats, extinguished uint64) {
+	ids := make([]string, 0, len(traffic))
+	for id := range traffic {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Println("\nTRAFFIC:")
+	var sent uint64
+	for _, id := range ids {
+		s := traffic[id]
+		sent += s.Sent
+		fmt.Printf("  %s: %d messages (%d bytes) sent, %d handler errors, %d decode errors, %d rejected, %d expired\n",
+			id, s.Sent, s.BytesSent, s.HandlerErrors, s.DecodeErrors, s.Invalid, s.Expired)
+	}
+	if extinguished > 0 {
+		fmt.Printf("  %d messages per extinguished fire (%d fires)\n", sent/extinguished, extinguished)
+	}
+}
+
+// Lists messages each node lost or received twice, per sender and channel
+func printLosses(losses map[string][]transport.SeqStats) {
+	receivers := make([]string, 0, len(losses))
+	for id := range losses {
+		receivers = append(receivers, id)
+	}
+	sort.Strings(receivers)
+
+	fmt.Println("\nMESSAGE LOSS:")
+	clean := true
+	for _, id := range receivers {
+		for _, s := range losses[id] {
+			if s.Missing() == 0 && s.Duplicates == 0 {
+				continue
+			}
+			clean = false
+			fmt.Printf("  %s <- %s on %s: %d missing, %d late, %d duplicates (%d received)\n",
+				id, s.Peer, s.Channel, s.Missing(), s.Late, s.Duplicates, s.Received)
+		}
+	}
+	if clean {
+		fmt.Println("  none")
+	}
+}
+
+// Lists the runs seen on the broker, forgetting nodes that went quiet
+func printRuns(runs map[string]map[string]time.Time) {
+	fmt.Println("\nRUNS ON BROKER:")
+	names := make([]string, 0, len(runs))
+	for run, nodes := range runs {
+		for id, seen := range nodes {
+			if time.Since(seen) > 15*time.Second {
+				delete(nodes, id)
+			}
+		}
+		if len(nodes) == 0 {
+			delete(runs, run)
+			continue
+		}
+		names = append(names, run)
+	}
+	sort.Strings(names)
+
+	for _, run := range names {
+		ids := make([]string, 0, len(runs[run]))
+		for id := range runs[run] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		label := run
+		if label == "" {
+			label = "(default)"
+		}
+		fmt.Printf("  %s: %s\n", label, strings.Join(ids, ", "))
 	}
 }
 
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"Firetruck-sim/pkg/record"
+)
+
+// Checks the Lamport clocks in traces written with -trace, or recordings
+// made by the recorder role, and lists every violation it finds
+func main() {
+	examples := flag.Int("examples", 5, "violations to print per kind (0 prints all)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: verify [flags] <trace.jsonl>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var entries []record.Entry
+	for _, path := range flag.Args() {
+		list, err := record.Load(path)
+		if err != nil {
+			log.Fatalf("Failed to load log: %v", err)
+		}
+		entries = append(entries, list...)
+	}
+
+	report := record.Verify(entries)
+	fmt.Printf("Checked %d entries from %d nodes (%d sends, %d receives traced)\n",
+		report.Entries, len(report.Nodes), report.Sends, report.Receives)
+
+	kinds := []string{record.ViolationReceive, record.ViolationRegressed, record.ViolationHardcoded}
+	for _, kind := range kinds {
+		n := report.Count(kind)
+		fmt.Printf("\n%s: %d\n", kind, n)
+
+		shown := 0
+		for _, v := range report.Violations {
+			if v.Kind != kind {
+				continue
+			}
+			if *examples > 0 && shown == *examples {
+				fmt.Printf("  ... %d more\n", n-shown)
+				break
+			}
+			fmt.Printf("  %s [%s] %s\n", v.Entry.Time.Format("15:04:05.000"), v.Node, v.Detail)
+			shown++
+		}
+	}
+
+	if len(report.Violations) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,104 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a logical clock that timestamps events and messages. Both
+// LamportClock and HybridClock implement it.
+type Clock interface {
+	// Now returns the current timestamp without advancing the clock
+	Now() int64
+	// Tick advances the clock for a local event and returns the timestamp
+	Tick() int64
+	// Receive advances the clock past a timestamp received from another
+	// process and returns the new timestamp
+	Receive(other int64) int64
+}
+
+// Hybrid timestamps pack milliseconds since hlcEpoch above a logical
+// counter of hlcLogicalBits bits. The result stays below 2^53, so it
+// survives being decoded from JSON as a float64.
+const hlcLogicalBits = 10
+
+var hlcEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// HybridClock implements a hybrid logical clock: timestamps follow the
+// wall clock to the millisecond but, like a Lamport clock, always exceed
+// every timestamp seen before, so causality is preserved under clock skew.
+type HybridClock struct {
+	mu      sync.Mutex
+	wall    int64 // milliseconds since hlcEpoch
+	logical int64
+	now     func() time.Time // time.Now, or a fake in tests
+}
+
+// NewHybridClock creates a hybrid logical clock driven by time.Now.
+func NewHybridClock() *HybridClock {
+	return &HybridClock{now: time.Now}
+}
+
+// HybridTime returns the wall-clock time of a hybrid timestamp.
+func HybridTime(ts int64) time.Time {
+	return hlcEpoch.Add(time.Duration(ts>>hlcLogicalBits) * time.Millisecond)
+}
+
+// Now returns the current timestamp without advancing the clock.
+func (hc *HybridClock) Now() int64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.pack()
+}
+
+// Tick advances the clock for a local event and returns the timestamp.
+func (hc *HybridClock) Tick() int64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	pt := hc.physical()
+	if pt > hc.wall {
+		hc.wall, hc.logical = pt, 0
+	} else {
+		hc.bump()
+	}
+	return hc.pack()
+}
+
+// Receive advances the clock past a timestamp from another process.
+func (hc *HybridClock) Receive(other int64) int64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	pt := hc.physical()
+	ow, ol := other>>hlcLogicalBits, other&(1<<hlcLogicalBits-1)
+	switch {
+	case pt > hc.wall && pt > ow:
+		hc.wall, hc.logical = pt, 0
+		return hc.pack()
+	case ow > hc.wall:
+		hc.wall, hc.logical = ow, ol
+	case ow == hc.wall && ol > hc.logical:
+		hc.logical = ol
+	}
+	hc.bump()
+	return hc.pack()
+}
+
+// bump increments the logical counter, borrowing a millisecond from the
+// future if it overflows.
+func (hc *HybridClock) bump() {
+	hc.logical++
+	if hc.logical >= 1<<hlcLogicalBits {
+		hc.wall++
+		hc.logical = 0
+	}
+}
+
+func (hc *HybridClock) physical() int64 {
+	return hc.now().Sub(hlcEpoch).Milliseconds()
+}
+
+func (hc *HybridClock) pack() int64 {
+	return hc.wall<<hlcLogicalBits | hc.logical
+}
@@ -0,0 +1,146 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeTime is a wall clock the tests move by hand.
+type fakeTime struct {
+	t time.Time
+}
+
+func (f *fakeTime) now() time.Time { return f.t }
+
+func newTestHLC(start time.Time) (*HybridClock, *fakeTime) {
+	ft := &fakeTime{t: start}
+	hc := NewHybridClock()
+	hc.now = ft.now
+	return hc, ft
+}
+
+// hlc packs a timestamp the way HybridClock does.
+func hlc(ms, logical int64) int64 {
+	return ms<<hlcLogicalBits | logical
+}
+
+func split(ts int64) (ms, logical int64) {
+	return ts >> hlcLogicalBits, ts & (1<<hlcLogicalBits - 1)
+}
+
+var testStart = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+func msSinceEpoch(t time.Time) int64 {
+	return t.Sub(hlcEpoch).Milliseconds()
+}
+
+func TestHybridClockPacking(t *testing.T) {
+	for _, at := range []time.Time{
+		hlcEpoch.Add(time.Millisecond),
+		testStart.Add(123456 * time.Microsecond),
+		time.Date(2290, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		hc, _ := newTestHLC(at)
+		ts := hc.Tick()
+		ms, logical := split(ts)
+		if ms != msSinceEpoch(at) || logical != 0 {
+			t.Errorf("%v: Tick = %d ms + %d, want %d ms + 0", at, ms, logical, msSinceEpoch(at))
+		}
+		if got, want := HybridTime(ts), at.Truncate(time.Millisecond); !got.Equal(want) {
+			t.Errorf("%v: HybridTime = %v, want %v", at, got, want)
+		}
+		// Timestamps must survive a round trip through a JSON float64
+		if ts >= 1<<53 || int64(float64(ts)) != ts {
+			t.Errorf("%v: timestamp %d does not fit a float64 exactly", at, ts)
+		}
+	}
+}
+
+func TestHybridClockTick(t *testing.T) {
+	hc, ft := newTestHLC(testStart)
+	ms := msSinceEpoch(testStart)
+
+	for _, tc := range []struct {
+		name    string
+		advance time.Duration
+		want    int64
+	}{
+		{"first tick", 0, hlc(ms, 0)},
+		{"same millisecond", 0, hlc(ms, 1)},
+		{"within the millisecond", 500 * time.Microsecond, hlc(ms, 2)},
+		{"wall clock moves on", 2 * time.Millisecond, hlc(ms+2, 0)},
+		{"wall clock goes back", -time.Second, hlc(ms+2, 1)},
+		{"still behind", 10 * time.Millisecond, hlc(ms+2, 2)},
+		{"caught up again", time.Second, hlc(ms+12, 0)},
+	} {
+		ft.t = ft.t.Add(tc.advance)
+		if got := hc.Tick(); got != tc.want {
+			gm, gl := split(got)
+			wm, wl := split(tc.want)
+			t.Errorf("%s: Tick = %d ms + %d, want %d ms + %d", tc.name, gm-ms, gl, wm-ms, wl)
+		}
+	}
+	if now := hc.Now(); now != hlc(ms+12, 0) {
+		t.Errorf("Now advanced the clock to %d", now)
+	}
+}
+
+func TestHybridClockReceive(t *testing.T) {
+	ms := msSinceEpoch(testStart)
+	for _, tc := range []struct {
+		name  string
+		local int64 // timestamp of the local clock before receiving
+		skew  time.Duration
+		other int64
+		want  int64
+	}{
+		{"remote and local behind the wall clock", hlc(ms-5, 3), 0, hlc(ms-2, 7), hlc(ms, 0)},
+		{"remote ahead of the wall clock", hlc(ms, 0), 0, hlc(ms+40, 2), hlc(ms+40, 3)},
+		{"remote ahead in the same millisecond", hlc(ms+40, 1), 0, hlc(ms+40, 5), hlc(ms+40, 6)},
+		{"remote behind in the same millisecond", hlc(ms+40, 5), 0, hlc(ms+40, 1), hlc(ms+40, 6)},
+		{"local ahead of remote and wall clock", hlc(ms+40, 5), 0, hlc(ms+10, 9), hlc(ms+40, 6)},
+		{"wall clock skewed back", hlc(ms, 8), -time.Minute, hlc(ms-100, 0), hlc(ms, 9)},
+		{"remote skewed far back", hlc(ms, 0), 0, hlc(ms-60000, 0), hlc(ms, 1)},
+	} {
+		hc, _ := newTestHLC(testStart.Add(tc.skew))
+		hc.wall, hc.logical = split(tc.local)
+		got := hc.Receive(tc.other)
+		if got != tc.want {
+			gm, gl := split(got)
+			wm, wl := split(tc.want)
+			t.Errorf("%s: Receive = %d ms + %d, want %d ms + %d", tc.name, gm-ms, gl, wm-ms, wl)
+		}
+		if got <= tc.other || got <= tc.local {
+			t.Errorf("%s: Receive = %d, not past both %d and %d", tc.name, got, tc.local, tc.other)
+		}
+	}
+}
+
+func TestHybridClockLogicalOverflow(t *testing.T) {
+	// The wall clock stands still, so every tick uses the logical counter
+	hc, _ := newTestHLC(testStart)
+	ms := msSinceEpoch(testStart)
+
+	prev := hc.Tick()
+	for i := 1; i < 1<<hlcLogicalBits; i++ {
+		ts := hc.Tick()
+		if ts <= prev {
+			t.Fatalf("tick %d: %d not after %d", i, ts, prev)
+		}
+		prev = ts
+	}
+	if want := hlc(ms, 1<<hlcLogicalBits-1); prev != want {
+		t.Fatalf("last tick in the millisecond = %d, want %d", prev, want)
+	}
+
+	// The next event borrows a millisecond from the future
+	if got, want := hc.Tick(), hlc(ms+1, 0); got != want {
+		t.Errorf("overflowing tick = %d, want %d", got, want)
+	}
+
+	// Receiving a full counter from a peer overflows the same way
+	hc, _ = newTestHLC(testStart)
+	if got, want := hc.Receive(hlc(ms+5, 1<<hlcLogicalBits-1)), hlc(ms+6, 0); got != want {
+		t.Errorf("Receive of a full counter = %d, want %d", got, want)
+	}
+}
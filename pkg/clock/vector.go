@@ -0,0 +1,137 @@
+package clock
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Ordering is the causal relation between two vector timestamps.
+type Ordering int
+
+const (
+	Equal      Ordering = iota // same events seen
+	Before                     // happened before the other
+	After                      // happened after the other
+	Concurrent                 // neither saw the other
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	case Concurrent:
+		return "concurrent"
+	default:
+		return "equal"
+	}
+}
+
+// Vector is a vector timestamp: the number of events seen from each process.
+// Missing entries count as zero. It encodes to JSON as an object keyed by
+// process ID.
+type Vector map[string]uint64
+
+// Copy returns an independent copy of v.
+func (v Vector) Copy() Vector {
+	c := make(Vector, len(v))
+	for id, n := range v {
+		c[id] = n
+	}
+	return c
+}
+
+// Compare tells whether v happened before, after, or concurrently with o.
+func (v Vector) Compare(o Vector) Ordering {
+	less, greater := false, false
+	for id, n := range v {
+		if n > o[id] {
+			greater = true
+		} else if n < o[id] {
+			less = true
+		}
+	}
+	for id, n := range o {
+		if _, ok := v[id]; !ok && n > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// VectorClock implements a vector clock for one process, which unlike a
+// LamportClock can tell concurrent events from causally ordered ones.
+type VectorClock struct {
+	mu sync.Mutex
+	id string
+	v  Vector
+}
+
+// NewVectorClock creates a vector clock for the process id, starting at zero.
+func NewVectorClock(id string) *VectorClock {
+	return &VectorClock{id: id, v: make(Vector)}
+}
+
+// Now returns a copy of the current timestamp without incrementing it.
+func (vc *VectorClock) Now() Vector {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.v.Copy()
+}
+
+// Tick counts a local event, such as sending a message, and returns the
+// new timestamp.
+func (vc *VectorClock) Tick() Vector {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.v[vc.id]++
+	return vc.v.Copy()
+}
+
+// Merge updates the clock when receiving a message stamped with other:
+// every entry becomes the maximum of both, then the receive is counted as
+// a local event.
+func (vc *VectorClock) Merge(other Vector) Vector {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	for id, n := range other {
+		if n > vc.v[id] {
+			vc.v[id] = n
+		}
+	}
+	vc.v[vc.id]++
+	return vc.v.Copy()
+}
+
+// MarshalJSON encodes the current timestamp.
+func (vc *VectorClock) MarshalJSON() ([]byte, error) {
+	return json.Marshal(vc.Now())
+}
+
+// UnmarshalJSON restores a timestamp encoded by MarshalJSON, keeping the
+// clock's process ID.
+func (vc *VectorClock) UnmarshalJSON(data []byte) error {
+	var v Vector
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		v = make(Vector)
+	}
+
+	vc.mu.Lock()
+	vc.v = v
+	vc.mu.Unlock()
+	return nil
+}
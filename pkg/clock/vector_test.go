@@ -0,0 +1,96 @@
+package clock
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestVectorCompare(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v, o Vector
+		want Ordering
+	}{
+		{"both empty", Vector{}, nil, Equal},
+		{"same", Vector{"A": 2, "B": 1}, Vector{"A": 2, "B": 1}, Equal},
+		{"missing counts as zero", Vector{"A": 1, "B": 0}, Vector{"A": 1}, Equal},
+		{"one entry behind", Vector{"A": 1, "B": 1}, Vector{"A": 2, "B": 1}, Before},
+		{"all entries behind", Vector{"A": 1}, Vector{"A": 2, "B": 3}, Before},
+		{"empty is before anything", nil, Vector{"C": 1}, Before},
+		{"one entry ahead", Vector{"A": 3, "B": 1}, Vector{"A": 2, "B": 1}, After},
+		{"other missing an entry", Vector{"A": 2, "B": 1}, Vector{"A": 2}, After},
+		{"crossed", Vector{"A": 2, "B": 1}, Vector{"A": 1, "B": 2}, Concurrent},
+		{"disjoint", Vector{"A": 1}, Vector{"B": 1}, Concurrent},
+	} {
+		if got := tc.v.Compare(tc.o); got != tc.want {
+			t.Errorf("%s: %v.Compare(%v) = %v, want %v", tc.name, tc.v, tc.o, got, tc.want)
+		}
+		// The relation is the same seen from the other side
+		flipped := map[Ordering]Ordering{Equal: Equal, Before: After, After: Before, Concurrent: Concurrent}
+		if got := tc.o.Compare(tc.v); got != flipped[tc.want] {
+			t.Errorf("%s: %v.Compare(%v) = %v, want %v", tc.name, tc.o, tc.v, got, flipped[tc.want])
+		}
+	}
+}
+
+func TestVectorClockMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		local, other Vector
+		want         Vector
+	}{
+		{"first message", Vector{}, Vector{"B": 3}, Vector{"A": 1, "B": 3}},
+		{"entrywise maximum", Vector{"A": 2, "B": 5, "C": 1}, Vector{"B": 4, "C": 2}, Vector{"A": 3, "B": 5, "C": 2}},
+		{"other ahead of us on our own entry", Vector{"A": 1}, Vector{"A": 4}, Vector{"A": 5}},
+		{"nothing new", Vector{"A": 2, "B": 2}, Vector{"B": 1}, Vector{"A": 3, "B": 2}},
+	} {
+		vc := NewVectorClock("A")
+		vc.v = tc.local.Copy()
+		got := vc.Merge(tc.other)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Merge = %v, want %v", tc.name, got, tc.want)
+		}
+		if got.Compare(tc.other) != After {
+			t.Errorf("%s: merged %v is not after %v", tc.name, got, tc.other)
+		}
+	}
+}
+
+func TestVectorClockTick(t *testing.T) {
+	vc := NewVectorClock("A")
+	first := vc.Tick()
+	second := vc.Tick()
+	if first.Compare(second) != Before {
+		t.Errorf("%v is not before %v", first, second)
+	}
+
+	// Returned timestamps are copies the clock does not change later
+	second["A"] = 100
+	if now := vc.Now(); now["A"] != 2 {
+		t.Errorf("Now = %v after changing a returned timestamp, want A:2", now)
+	}
+
+	// Sends by two processes that did not hear of each other are concurrent
+	b := NewVectorClock("B")
+	if got := vc.Tick().Compare(b.Tick()); got != Concurrent {
+		t.Errorf("independent ticks are %v, want concurrent", got)
+	}
+}
+
+func TestVectorClockJSON(t *testing.T) {
+	vc := NewVectorClock("A")
+	vc.Merge(Vector{"B": 2})
+	data, err := json.Marshal(vc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewVectorClock("A")
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Tick(); !reflect.DeepEqual(got, Vector{"A": 2, "B": 2}) {
+		t.Errorf("restored clock ticked to %v, want A:2 B:2", got)
+	}
+}
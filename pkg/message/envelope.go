@@ -0,0 +1,57 @@
+package message
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Message IDs are the sender, a tag for this process and a counter, so
+// they stay unique across restarts without coordination.
+var (
+	processTag = randomHex(3)
+	idCounter  atomic.Uint64
+)
+
+// Stamp fills in the envelope fields the sender left empty: a new ID, and
+// a correlation and trace of the message's own. From must be set.
+func (m *Message) Stamp() {
+	if m.ID == "" {
+		m.ID = fmt.Sprintf("%s-%s-%d", m.From, processTag, idCounter.Add(1))
+	}
+	if m.Correlation == "" {
+		m.Correlation = m.ID
+	}
+	if m.Trace == "" {
+		m.Trace = randomHex(16)
+	}
+}
+
+// Follow makes m part of the incident and trace of cause, as its child.
+func (m *Message) Follow(cause Message) {
+	m.Correlation = cause.Correlation
+	if m.Correlation == "" {
+		m.Correlation = cause.ID
+	}
+	m.Trace = cause.Trace
+	m.Parent = cause.ID
+}
+
+// ExpireIn makes m expire ttl from now.
+func (m *Message) ExpireIn(ttl time.Duration) {
+	m.Expires = time.Now().Add(ttl).UnixMilli()
+}
+
+// Expired tells whether m had expired at now.
+func (m Message) Expired(now time.Time) bool {
+	return m.Expires != 0 && now.UnixMilli() > m.Expires
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,5 +1,12 @@
 package message
 
+import (
+	"bytes"
+	"encoding/json"
+
+	"Firetruck-sim/pkg/clock"
+)
+
 // Message types for inter-truck communication
 const (
 	TypeMoveCommand    = "move_command"
@@ -11,117 +18,113 @@ const (
 	TypeFireAssignment = "fire_assignment"
 	TypeWaterRequest   = "water_request"
 	TypeWaterResponse  = "water_response"
-	TypeFireAnnounce   = "fire_announce"
+	TypeFireAnnounce   = "fire_announce" // version 1 only, see Upgrade
 	TypeBid            = "bid"
 	TypeBidDecision    = "bid_decision"
 	TypeTick           = "tick"
 	TypeWaterReq       = "water_req"
 	TypeWaterReply     = "water_reply"
 	TypeWaterRelease   = "water_release"
+	TypePartition      = "partition"
+	TypeAssignmentAck  = "assignment_ack"
+	TypeStateQuery     = "state_query"
+	TypeStateSnapshot  = "state_snapshot"
+	TypeRunAnnounce    = "run_announce"
+	TypeStatsReport    = "stats_report"
+	TypeOrder          = "order"
+	TypeSnapshotMarker = "snapshot_marker"
+	TypeSnapshotReport = "snapshot_report"
+	TypeDeadLetter     = "dead_letter"
 )
 
 // Represents a communication message between fire trucks
 type Message struct {
+	// Version is the message format, set by the transport. Zero means a
+	// node older than versioning, which wrote version 1.
+	Version int                    `json:"v,omitempty"`
 	Type    string                 `json:"type"`
 	From    string                 `json:"from"`
 	Lamport int64                  `json:"lamport"`
 	Payload map[string]interface{} `json:"payload,omitempty"`
-}
 
-// Creates a new message with the specified type and payload
-func NewMessage(msgType, from string, payload map[string]interface{}) Message {
-	return Message{
-		Type:    msgType,
-		From:    from,
-		Payload: payload,
-	}
-}
+	// Envelope metadata, see Stamp and Follow. ID is unique to the message
+	// and serves as its span in the trace. Correlation is the ID of the
+	// message that started the incident this one belongs to, such as the
+	// fire alert behind a bid, and Parent the ID of the message it answers.
+	ID          string `json:"id,omitempty"`
+	Correlation string `json:"corr,omitempty"`
+	Trace       string `json:"trace,omitempty"`
+	Parent      string `json:"parent,omitempty"`
 
-// Creates a payload for fire alert messages
-func FireAlertPayload(row, col, intensity int) map[string]interface{} {
-	return map[string]interface{}{
-		"row":       row,
-		"col":       col,
-		"intensity": intensity,
-	}
-}
+	// Expires is the wall-clock time in Unix milliseconds after which
+	// receivers drop the message as stale. Zero never expires.
+	Expires int64 `json:"expires,omitempty"`
 
-// Creates a payload for truck status broadcast messages
-func TruckStatusPayload(row, col, water, maxWater int, task string) map[string]interface{} {
-	return map[string]interface{}{
-		"row":       row,
-		"col":       col,
-		"water":     water,
-		"max_water": maxWater,
-		"task":      task,
-	}
-}
+	// Seq numbers the messages a sender publishes on each channel, starting
+	// at 1 for every Epoch (the sender's start time). Zero means unsequenced.
+	Seq   uint64 `json:"seq,omitempty"`
+	Epoch int64  `json:"epoch,omitempty"`
 
-// Creates a payload for coordination messages
-func CoordinationPayload(action string, targetRow, targetCol int, details map[string]interface{}) map[string]interface{} {
-	payload := map[string]interface{}{
-		"action":     action,
-		"target_row": targetRow,
-		"target_col": targetCol,
-	}
-	for k, v := range details {
-		payload[k] = v
-	}
-	return payload
-}
+	// Vector is the sender's vector timestamp, carried when vector clocks
+	// are enabled on the transport
+	Vector clock.Vector `json:"vclock,omitempty"`
 
-// Creates a payload for fire bidding messages
-func FireBidPayload(fireRow, fireCol, distance, water int, truckID string) map[string]interface{} {
-	return map[string]interface{}{
-		"fire_row": fireRow,
-		"fire_col": fireCol,
-		"distance": distance,
-		"water":    water,
-		"truck_id": truckID,
-	}
-}
+	// Causal lists the messages this one causally depends on, set by the
+	// causal delivery layer
+	Causal clock.Vector `json:"causal,omitempty"`
 
-// Creates a payload for fire assignment result messages
-func FireAssignmentPayload(fireRow, fireCol int, assignedTruck string, reason string) map[string]interface{} {
-	return map[string]interface{}{
-		"fire_row":       fireRow,
-		"fire_col":       fireCol,
-		"assigned_truck": assignedTruck,
-		"reason":         reason,
-	}
-}
+	// Snapshot is the latest global snapshot the sender had recorded when
+	// sending, which tells receivers whether it was sent before the cut
+	Snapshot uint64 `json:"snapshot,omitempty"`
 
-// Typed payloads
-type FireID struct{ X, Y int }
+	// To is the channel the message was sent on, or for a response the
+	// reply channel of its request. It is signed, so a captured message
+	// cannot be replayed onto another channel or into another node's inbox.
+	To string `json:"to,omitempty"`
 
-type FireAnnounce struct {
-	ID        FireID
-	Intensity int
-	Tick      uint64
-}
+	// ReplyTo is the channel a response should go to, set on requests
+	ReplyTo string `json:"reply_to,omitempty"`
 
-type Bid struct {
-	Fire    FireID
-	Bidder  string
-	Score   int
-	Lamport int
+	// Signature authenticates the sender, see Canonical
+	Signature string `json:"sig,omitempty"`
+
+	// Channel is the channel a message was received on. It is set on
+	// delivery and never sent.
+	Channel string `json:"-"`
 }
 
-type BidDecision struct {
-	Fire    FireID
-	Winner  string
-	Lamport int
+// Canonical returns the bytes a message signature is computed over, see
+// CanonicalJSON. Payload values must be JSON primitives, maps or slices so
+// that a decoded message encodes to the same bytes as the original.
+func Canonical(msg Message) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return CanonicalJSON(data)
 }
 
-type Tick struct {
-	Tick uint64
-	Seed int64
+// CanonicalJSON returns the canonical form of an encoded message: its JSON
+// object without signature and reply channel, with keys sorted and numbers
+// kept as their literals. Fields the reader does not know are kept, so
+// nodes of different versions agree on the signature.
+func CanonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "sig")
+	delete(fields, "reply_to")
+	return json.Marshal(fields)
 }
 
-// RA messages
-type WaterReq struct {
-	From string
-	TS   int
+// Creates a new message with the specified type and payload
+func NewMessage(msgType, from string, payload map[string]interface{}) Message {
+	return Message{
+		Type:    msgType,
+		From:    from,
+		Payload: payload,
+	}
 }
-type WaterReply struct{ From string }
-type WaterRelease struct{ From string }
@@ -0,0 +1,231 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Encode converts a typed payload to the map a Message carries. Numbers are
+// kept as their JSON literals so that signatures still verify.
+func Encode[T any](payload T) (map[string]interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", payload, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out map[string]interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", payload, err)
+	}
+	return out, nil
+}
+
+// Decode converts the payload of msg to T. A missing payload decodes to the
+// zero value; fields of the wrong type are an error.
+func Decode[T any](msg Message) (T, error) {
+	var out T
+	data, err := json.Marshal(msg.Payload)
+	if err != nil {
+		return out, fmt.Errorf("invalid %s payload from %s: %w", msg.Type, msg.From, err)
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("invalid %s payload from %s: %w", msg.Type, msg.From, err)
+	}
+	return out, nil
+}
+
+// New creates a message of the given type with a typed payload.
+func New[T any](msgType, from string, payload T) (Message, error) {
+	p, err := Encode(payload)
+	if err != nil {
+		return Message{}, err
+	}
+	return NewMessage(msgType, from, p), nil
+}
+
+// MoveCommand (TypeMoveCommand) tells a truck where to go.
+type MoveCommand struct {
+	Row int `json:"row"`
+	Col int `json:"col"`
+}
+
+// FireAlert (TypeFireAlert) reports a fire, found by a truck or spread by
+// the simulation.
+type FireAlert struct {
+	Row       int `json:"row"`
+	Col       int `json:"col"`
+	Intensity int `json:"intensity"`
+}
+
+// Coordination (TypeCoordination) announces what a truck is doing at a
+// target cell. Moves carry where the truck came from, extinguishes how much
+// water they took.
+type Coordination struct {
+	Action    string `json:"action"`
+	TargetRow int    `json:"target_row"`
+	TargetCol int    `json:"target_col"`
+	FromRow   *int   `json:"from_row,omitempty"`
+	FromCol   *int   `json:"from_col,omitempty"`
+	WaterUsed int    `json:"water_used,omitempty"`
+}
+
+// TruckStatus (TypeTruckStatus) is a truck's periodic status broadcast.
+type TruckStatus struct {
+	Row      int    `json:"row"`
+	Col      int    `json:"col"`
+	Water    int    `json:"water"`
+	MaxWater int    `json:"max_water"`
+	Task     string `json:"task"`
+}
+
+// WaterBroadcast (TypeWaterBroadcast) shares a truck's water level.
+type WaterBroadcast struct {
+	Water    int `json:"water"`
+	MaxWater int `json:"max_water"`
+}
+
+// FireBid (TypeFireBid) is a truck's bid to respond to a fire.
+type FireBid struct {
+	FireRow  int    `json:"fire_row"`
+	FireCol  int    `json:"fire_col"`
+	Distance int    `json:"distance"`
+	Water    int    `json:"water"`
+	TruckID  string `json:"truck_id"`
+}
+
+// FireAssignment (TypeFireAssignment) offers a fire to a bidder.
+type FireAssignment struct {
+	FireRow       int    `json:"fire_row"`
+	FireCol       int    `json:"fire_col"`
+	AssignedTruck string `json:"assigned_truck"`
+	Reason        string `json:"reason"`
+}
+
+// WaterRequest (TypeWaterRequest) asks the water supply for water.
+type WaterRequest struct {
+	Amount int `json:"amount"`
+}
+
+// WaterResponse (TypeWaterResponse) is the water the supply granted.
+type WaterResponse struct {
+	Amount int `json:"amount"`
+}
+
+// FireAnnounce (TypeFireAnnounce) reports a fire the simulation spread.
+// Only version 1 writes it; Upgrade turns it into a FireAlert.
+type FireAnnounce struct {
+	X         int    `json:"id_x"`
+	Y         int    `json:"id_y"`
+	Intensity int    `json:"intensity"`
+	Tick      uint64 `json:"tick"`
+}
+
+// Bid (TypeBid) is a truck's score for a fire; lower wins.
+type Bid struct {
+	FireX   int    `json:"fire_x"`
+	FireY   int    `json:"fire_y"`
+	Bidder  string `json:"bidder"`
+	Score   int    `json:"score"`
+	Lamport int    `json:"lamport"`
+}
+
+// BidDecision (TypeBidDecision) names the truck that won a fire, or none.
+type BidDecision struct {
+	FireX   int    `json:"fire_x"`
+	FireY   int    `json:"fire_y"`
+	Winner  string `json:"winner"`
+	Lamport int    `json:"lamport"`
+}
+
+// Tick (TypeTick) advances a shared simulation.
+type Tick struct {
+	Tick uint64 `json:"tick"`
+	Seed int64  `json:"seed"`
+}
+
+// RA messages. The sender is the message's From.
+
+// WaterReq (TypeWaterReq) asks every peer for the critical section.
+type WaterReq struct {
+	TS int `json:"ts"`
+}
+
+// WaterReply (TypeWaterReply) grants a peer the critical section.
+type WaterReply struct{}
+
+// WaterRelease (TypeWaterRelease) leaves the critical section.
+type WaterRelease struct{}
+
+// Partition (TypePartition) installs a network partition, see
+// transport.ParsePartition. An empty spec heals the network.
+type Partition struct {
+	Spec string `json:"spec"`
+}
+
+// AssignmentAck (TypeAssignmentAck) answers a FireAssignment.
+type AssignmentAck struct {
+	FireRow  int  `json:"fire_row"`
+	FireCol  int  `json:"fire_col"`
+	Accepted bool `json:"accepted"`
+}
+
+// StateQuery (TypeStateQuery) asks for the fires currently known.
+type StateQuery struct{}
+
+// StateSnapshot (TypeStateSnapshot) answers a StateQuery.
+type StateSnapshot struct {
+	Fires []FireAlert `json:"fires"`
+}
+
+// RunAnnounce (TypeRunAnnounce) tells observers which run a node is in.
+type RunAnnounce struct {
+	Run  string `json:"run"`
+	Role string `json:"role"`
+}
+
+// StreamStats counts what a node received from one peer on one channel.
+type StreamStats struct {
+	Peer       string `json:"peer"`
+	Channel    string `json:"channel"`
+	Received   uint64 `json:"received"`
+	Duplicates uint64 `json:"duplicates"`
+	Gaps       uint64 `json:"gaps"`
+	Late       uint64 `json:"late"`
+}
+
+// StatsReport (TypeStatsReport) is a node's traffic and the streams it
+// lost or received twice messages on.
+type StatsReport struct {
+	Streams       []StreamStats `json:"streams"`
+	Sent          uint64        `json:"sent"`
+	BytesSent     uint64        `json:"bytes_sent"`
+	HandlerErrors uint64        `json:"handler_errors"`
+	DecodeErrors  uint64        `json:"decode_errors"`
+	Invalid       uint64        `json:"invalid"`
+	Expired       uint64        `json:"expired,omitempty"`
+}
+
+// Order (TypeOrder) gives a message its position in the total order.
+type Order struct {
+	Position uint64 `json:"position"`
+	Msg      string `json:"msg"`
+}
+
+// SnapshotMarker (TypeSnapshotMarker) tells peers a node recorded its
+// state; the snapshot is the message's Snapshot.
+type SnapshotMarker struct{}
+
+// SnapshotReport (TypeSnapshotReport) is a node's part in a global
+// snapshot, a transport.NodeSnapshot.
+type SnapshotReport struct {
+	Node json.RawMessage `json:"node"`
+}
+
+// DeadLetter (TypeDeadLetter) reports a message a node rejected and why.
+type DeadLetter struct {
+	Channel string  `json:"channel"`
+	Reason  string  `json:"reason"`
+	Message Message `json:"msg"`
+}
@@ -0,0 +1,269 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Check validates the decoded payload of one message type against limits.
+type Check func(msg Message, limits Limits) error
+
+// Limits are the values payloads are checked against.
+type Limits struct {
+	// GridSize bounds coordinates to [0, GridSize). Zero skips the check.
+	GridSize int
+	// Nodes are the IDs allowed to send, and to be named as bidders and
+	// winners. Nil allows any.
+	Nodes map[string]bool
+}
+
+// Registry holds the checks for every message type.
+type Registry struct {
+	limits Limits
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// NewRegistry returns a registry with checks for every Type* constant.
+func NewRegistry(limits Limits) *Registry {
+	r := &Registry{limits: limits, checks: make(map[string]Check)}
+
+	r.Register(TypeMoveCommand, Schema(func(p MoveCommand, l Limits) error {
+		return l.cell("", p.Row, p.Col)
+	}))
+	r.Register(TypeFireAlert, Schema(func(p FireAlert, l Limits) error {
+		return all(l.cell("", p.Row, p.Col), nonNegative("intensity", p.Intensity))
+	}))
+	r.Register(TypeCoordination, Schema(func(p Coordination, l Limits) error {
+		if p.Action == "" {
+			return errors.New("empty action")
+		}
+		err := all(l.cell("target_", p.TargetRow, p.TargetCol), nonNegative("water_used", p.WaterUsed))
+		if p.FromRow != nil || p.FromCol != nil {
+			if p.FromRow == nil || p.FromCol == nil {
+				return all(err, errors.New("from_row and from_col must be set together"))
+			}
+			err = all(err, l.cell("from_", *p.FromRow, *p.FromCol))
+		}
+		return err
+	}))
+	r.Register(TypeTruckStatus, Schema(func(p TruckStatus, l Limits) error {
+		return all(l.cell("", p.Row, p.Col), water(p.Water, p.MaxWater))
+	}))
+	r.Register(TypeWaterBroadcast, Schema(func(p WaterBroadcast, l Limits) error {
+		return water(p.Water, p.MaxWater)
+	}))
+	r.Register(TypeFireBid, Schema(func(p FireBid, l Limits) error {
+		return all(l.cell("fire_", p.FireRow, p.FireCol), nonNegative("distance", p.Distance),
+			nonNegative("water", p.Water), l.node("truck_id", p.TruckID))
+	}))
+	r.Register(TypeFireAssignment, Schema(func(p FireAssignment, l Limits) error {
+		return all(l.cell("fire_", p.FireRow, p.FireCol), l.node("assigned_truck", p.AssignedTruck))
+	}))
+	r.Register(TypeWaterRequest, Schema(func(p WaterRequest, l Limits) error {
+		return nonNegative("amount", p.Amount)
+	}))
+	r.Register(TypeWaterResponse, Schema(func(p WaterResponse, l Limits) error {
+		return nonNegative("amount", p.Amount)
+	}))
+	r.Register(TypeBid, Schema(func(p Bid, l Limits) error {
+		return all(l.cellXY("fire_", p.FireX, p.FireY), l.node("bidder", p.Bidder),
+			nonNegative("score", p.Score), nonNegative("lamport", p.Lamport))
+	}))
+	r.Register(TypeBidDecision, Schema(func(p BidDecision, l Limits) error {
+		// No winner means nobody accepted the fire
+		err := l.cellXY("fire_", p.FireX, p.FireY)
+		if p.Winner != "" {
+			err = all(err, l.node("winner", p.Winner))
+		}
+		return err
+	}))
+	r.Register(TypeTick, Schema[Tick](nil))
+	r.Register(TypeWaterReq, Schema(func(p WaterReq, l Limits) error {
+		return nonNegative("ts", p.TS)
+	}))
+	r.Register(TypeWaterReply, Schema[WaterReply](nil))
+	r.Register(TypeWaterRelease, Schema[WaterRelease](nil))
+	r.Register(TypePartition, Schema[Partition](nil))
+	r.Register(TypeAssignmentAck, Schema(func(p AssignmentAck, l Limits) error {
+		return l.cell("fire_", p.FireRow, p.FireCol)
+	}))
+	r.Register(TypeStateQuery, Schema[StateQuery](nil))
+	r.Register(TypeStateSnapshot, Schema(func(p StateSnapshot, l Limits) error {
+		var err error
+		for i, f := range p.Fires {
+			if e := all(l.cell("", f.Row, f.Col), nonNegative("intensity", f.Intensity)); e != nil {
+				err = all(err, fmt.Errorf("fires[%d]: %w", i, e))
+			}
+		}
+		return err
+	}))
+	r.Register(TypeRunAnnounce, Schema(func(p RunAnnounce, l Limits) error {
+		if p.Role == "" {
+			return errors.New("empty role")
+		}
+		return nil
+	}))
+	r.Register(TypeStatsReport, Schema[StatsReport](nil))
+	r.Register(TypeOrder, Schema(func(p Order, l Limits) error {
+		if p.Position == 0 || p.Msg == "" {
+			return errors.New("position and msg must be set")
+		}
+		return nil
+	}))
+	r.Register(TypeSnapshotMarker, func(msg Message, l Limits) error {
+		if msg.Snapshot == 0 {
+			return errors.New("marker without snapshot")
+		}
+		return nil
+	})
+	r.Register(TypeSnapshotReport, Schema[SnapshotReport](nil))
+	r.Register(TypeDeadLetter, Schema(func(p DeadLetter, l Limits) error {
+		if p.Channel == "" || p.Reason == "" {
+			return errors.New("channel and reason must be set")
+		}
+		return nil
+	}))
+	return r
+}
+
+// Register sets the check for a message type, replacing any earlier one.
+func (r *Registry) Register(msgType string, check Check) {
+	r.mu.Lock()
+	r.checks[msgType] = check
+	r.mu.Unlock()
+}
+
+// Validate checks the sender and payload of msg. Run announcements may
+// come from nodes of other runs, so their sender is not checked.
+func (r *Registry) Validate(msg Message) error {
+	if msg.Type == "" {
+		return errors.New("missing type")
+	}
+	if msg.From == "" {
+		return fmt.Errorf("%s without sender", msg.Type)
+	}
+	if msg.Type != TypeRunAnnounce {
+		if err := r.limits.node("sender", msg.From); err != nil {
+			return fmt.Errorf("%s: %w", msg.Type, err)
+		}
+	}
+
+	r.mu.RLock()
+	check, ok := r.checks[msg.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown message type %q", msg.Type)
+	}
+	if err := check(msg, r.limits); err != nil {
+		return fmt.Errorf("%s from %s: %w", msg.Type, msg.From, err)
+	}
+	return nil
+}
+
+// Schema returns a check that requires every field of T without omitempty,
+// decodes the payload into T and, unless valid is nil, checks its values.
+func Schema[T any](valid func(T, Limits) error) Check {
+	required := requiredFields(reflect.TypeFor[T]())
+	return func(msg Message, limits Limits) error {
+		var missing []string
+		for _, name := range required {
+			if _, ok := msg.Payload[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		p, err := Decode[T](msg)
+		if err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return fmt.Errorf("%s must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+			}
+			return err
+		}
+		if valid == nil {
+			return nil
+		}
+		return valid(p, limits)
+	}
+}
+
+// requiredFields lists the JSON names of the fields of struct type t that
+// have no omitempty option.
+func requiredFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" || name == "-" || strings.Contains(opts, "omitempty") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// cell checks that the coordinates <prefix>row and <prefix>col lie on the
+// grid.
+func (l Limits) cell(prefix string, row, col int) error {
+	return all(l.coord(prefix+"row", row), l.coord(prefix+"col", col))
+}
+
+// cellXY is cell for payloads naming coordinates <prefix>x and <prefix>y.
+func (l Limits) cellXY(prefix string, x, y int) error {
+	return all(l.coord(prefix+"x", x), l.coord(prefix+"y", y))
+}
+
+func (l Limits) coord(name string, v int) error {
+	if v < 0 || (l.GridSize > 0 && v >= l.GridSize) {
+		return fmt.Errorf("%s %d outside the grid", name, v)
+	}
+	return nil
+}
+
+// node checks that id names a known node.
+func (l Limits) node(field, id string) error {
+	if id == "" {
+		return fmt.Errorf("empty %s", field)
+	}
+	if l.Nodes != nil && !l.Nodes[id] {
+		return fmt.Errorf("unknown %s %q", field, id)
+	}
+	return nil
+}
+
+func nonNegative(name string, v int) error {
+	if v < 0 {
+		return fmt.Errorf("negative %s %d", name, v)
+	}
+	return nil
+}
+
+// water checks a tank level.
+func water(level, capacity int) error {
+	if level < 0 || level > capacity {
+		return fmt.Errorf("water %d outside [0, %d]", level, capacity)
+	}
+	return nil
+}
+
+// all combines the non-nil errors into one, on a single line.
+func all(errs ...error) error {
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
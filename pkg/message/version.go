@@ -0,0 +1,57 @@
+package message
+
+import "fmt"
+
+// Version is the message format this build writes.
+//
+//	1: fires spread by the observer are TypeFireAnnounce with id_x and id_y
+//	2: every fire is a TypeFireAlert with row and col
+const Version = 2
+
+// Upgrader converts a message of one version to the next.
+type Upgrader func(Message) (Message, error)
+
+// upgrades holds the Upgrader from each version to the next.
+var upgrades = map[int]Upgrader{
+	1: upgradeFireAnnounce,
+}
+
+// Upgrade converts msg from the version it was written in to Version, so
+// handlers only ever see the current format. Messages from newer versions
+// are returned unchanged; the fields they add are ignored.
+func Upgrade(msg Message) (Message, error) {
+	if msg.Version == 0 {
+		msg.Version = 1
+	}
+	for msg.Version < Version {
+		up, ok := upgrades[msg.Version]
+		if !ok {
+			return msg, fmt.Errorf("no upgrade from version %d", msg.Version)
+		}
+		from := msg.Version
+		var err error
+		if msg, err = up(msg); err != nil {
+			return msg, fmt.Errorf("failed to upgrade %s from version %d: %w", msg.Type, from, err)
+		}
+		msg.Version = from + 1
+	}
+	return msg, nil
+}
+
+// upgradeFireAnnounce turns a version 1 FireAnnounce into a FireAlert.
+func upgradeFireAnnounce(msg Message) (Message, error) {
+	if msg.Type != TypeFireAnnounce {
+		return msg, nil
+	}
+	announce, err := Decode[FireAnnounce](msg)
+	if err != nil {
+		return msg, err
+	}
+	payload, err := Encode(FireAlert{Row: announce.X, Col: announce.Y, Intensity: announce.Intensity})
+	if err != nil {
+		return msg, err
+	}
+	msg.Type = TypeFireAlert
+	msg.Payload = payload
+	return msg, nil
+}
@@ -0,0 +1,123 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+	"Firetruck-sim/pkg/transport"
+)
+
+// Entry is one recorded message with the time and channel it arrived on.
+// Entries written by a Tracer also name the node, whether it sent or
+// received the message, and its clock after doing so.
+type Entry struct {
+	Time    time.Time       `json:"time"`
+	Channel string          `json:"channel"`
+	Node    string          `json:"node,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	Clock   int64           `json:"clock,omitempty"`
+	Message message.Message `json:"msg"`
+}
+
+// Recorder appends every message it is given to a JSON-lines file.
+type Recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewRecorder opens path for appending, creating it if needed.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return &Recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends msg, stamped with the current time. It has the shape of a
+// SubscriptionHandler so it can be subscribed directly.
+func (r *Recorder) Record(msg message.Message) error {
+	return r.Write(Entry{Time: time.Now(), Channel: msg.Channel, Message: msg})
+}
+
+// Write appends e.
+func (r *Recorder) Write(e Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(e); err != nil {
+		return fmt.Errorf("failed to record message: %w", err)
+	}
+	return nil
+}
+
+// Close closes the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// Load reads every entry of a recording.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		e.Message.Channel = e.Channel
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return entries, nil
+}
+
+// Replay publishes entries into t in order. A speed of 1 keeps the
+// recorded gaps between messages, 2 halves them and 0 sends everything at
+// once. Messages are unchanged except for their expiry, which moves by as
+// long as the message was recorded ago, so receivers do not drop it.
+// Replies are skipped since nobody waits for them any more, and run
+// announcements since they would list the recorded nodes as live. It
+// returns how many messages were published.
+func Replay(t transport.Transport, entries []Entry, speed float64) (int, error) {
+	sent := 0
+	for i, e := range entries {
+		if i > 0 && speed > 0 {
+			gap := e.Time.Sub(entries[i-1].Time)
+			time.Sleep(time.Duration(float64(gap) / speed))
+		}
+		if e.Channel == "" || e.Channel == transport.ChannelRuns ||
+			strings.HasPrefix(e.Channel, "_INBOX.") {
+			continue
+		}
+		msg := e.Message
+		if msg.Expires != 0 {
+			msg.Expires += time.Since(e.Time).Milliseconds()
+		}
+		if err := t.Republish(e.Channel, msg); err != nil {
+			return sent, fmt.Errorf("failed to replay message %d: %w", i+1, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
@@ -0,0 +1,69 @@
+package record
+
+import (
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+	"Firetruck-sim/pkg/transport"
+)
+
+func TestReplayExpiringMessages(t *testing.T) {
+	keys := map[string]string{"T1": "s1", "CONTROL": "s2", "OBSERVER": "s3"}
+	bus := transport.NewMemBus()
+	sender := transport.NewMemTransport("T1", bus, transport.WithKeyring(transport.NewKeyring(keys)),
+		transport.WithTTL(transport.ChannelFireBids, time.Second))
+	defer sender.Close()
+
+	// Record a bid as the recorder role would
+	recorded := make(chan message.Message, 1)
+	rec := transport.NewMemTransport("REC", bus)
+	defer rec.Close()
+	if err := rec.Subscribe(transport.ChannelFireBids, func(msg message.Message) error {
+		recorded <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Publish(transport.ChannelFireBids, message.Message{Type: message.TypeBid}); err != nil {
+		t.Fatal(err)
+	}
+	var bid message.Message
+	select {
+	case bid = <-recorded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("bid not recorded")
+	}
+
+	// Pretend it was recorded a minute ago, long past its expiry
+	ago := time.Minute
+	bid.Expires -= ago.Milliseconds()
+	entries := []Entry{{Time: time.Now().Add(-ago), Channel: transport.ChannelFireBids, Message: bid}}
+
+	replayer := transport.NewMemTransport("CONTROL", bus, transport.WithKeyring(transport.NewKeyring(keys)),
+		transport.WithNamespace("replay"))
+	defer replayer.Close()
+	observer := transport.NewMemTransport("OBSERVER", bus, transport.WithKeyring(transport.NewKeyring(keys)),
+		transport.WithNamespace("replay"))
+	defer observer.Close()
+	delivered := make(chan message.Message, 1)
+	if err := observer.Subscribe(transport.ChannelFireBids, func(msg message.Message) error {
+		delivered <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := Replay(replayer, entries, 0); n != 1 || err != nil {
+		t.Fatalf("Replay = %d, %v, want 1 message", n, err)
+	}
+	select {
+	case msg := <-delivered:
+		if msg.From != "T1" || msg.Seq != bid.Seq {
+			t.Errorf("replayed message from %s seq %d, want T1 seq %d", msg.From, msg.Seq, bid.Seq)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("replayed bid not delivered (%d auth failures, %d expired)",
+			observer.AuthFailures(), observer.Stats().Totals().Expired)
+	}
+}
@@ -0,0 +1,130 @@
+package record
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"Firetruck-sim/pkg/clock"
+	"Firetruck-sim/pkg/message"
+	"Firetruck-sim/pkg/transport"
+)
+
+// Trace events. Entries written by a Recorder subscribed to the network
+// have no event.
+const (
+	EventSend    = "send"
+	EventReceive = "receive"
+)
+
+// Tracer wraps a Transport and records every message the node sends and
+// delivers, together with its logical clock, so that the clocks can be
+// checked offline with Verify. Set the node's clock through the Tracer and
+// leave Lamport unset on outgoing messages: the Tracer ticks the clock as
+// it records the send, so no receive can slip in between. A timestamp set
+// earlier is recorded as is, and Verify reports it if the clock has moved
+// past it since.
+type Tracer struct {
+	transport.Transport
+	rec *Recorder
+
+	mu    sync.Mutex // keeps entries in clock order
+	clock clock.Clock
+}
+
+// NewTracer wraps t so that its traffic is recorded to rec.
+func NewTracer(t transport.Transport, rec *Recorder) *Tracer {
+	return &Tracer{Transport: t, rec: rec}
+}
+
+// SetClock sets the clock of the node and remembers it for the trace.
+func (tr *Tracer) SetClock(c clock.Clock) {
+	tr.mu.Lock()
+	tr.clock = c
+	tr.mu.Unlock()
+	tr.Transport.SetClock(c)
+}
+
+// Publish records and publishes msg.
+func (tr *Tracer) Publish(channel string, msg message.Message) error {
+	msg = tr.sent(channel, msg)
+	return tr.Transport.Publish(channel, msg)
+}
+
+// Send records and sends msg.
+func (tr *Tracer) Send(to string, msg message.Message) error {
+	msg = tr.sent(transport.InboxChannel(to), msg)
+	return tr.Transport.Send(to, msg)
+}
+
+// Request records the request and its response.
+func (tr *Tracer) Request(channel string, msg message.Message, timeout time.Duration) (message.Message, error) {
+	msg = tr.sent(channel, msg)
+	resp, err := tr.Transport.Request(channel, msg, timeout)
+	if err == nil {
+		tr.received(resp)
+	}
+	return resp, err
+}
+
+// Reply records and sends resp.
+func (tr *Tracer) Reply(req message.Message, resp message.Message) error {
+	if resp.Parent == "" {
+		resp.Follow(req)
+	}
+	resp = tr.sent(req.ReplyTo, resp)
+	return tr.Transport.Reply(req, resp)
+}
+
+// Subscribe records every message before handler sees it.
+func (tr *Tracer) Subscribe(channel string, handler transport.SubscriptionHandler) error {
+	return tr.Transport.Subscribe(channel, tr.wrap(handler))
+}
+
+// SubscribeInbox records every direct message before handler sees it.
+func (tr *Tracer) SubscribeInbox(handler transport.SubscriptionHandler) error {
+	return tr.Transport.SubscribeInbox(tr.wrap(handler))
+}
+
+func (tr *Tracer) wrap(handler transport.SubscriptionHandler) transport.SubscriptionHandler {
+	return func(msg message.Message) error {
+		tr.received(msg)
+		return handler(msg)
+	}
+}
+
+// sent stamps msg the way the transport would and records it. The tick and
+// the entry happen under tr.mu, so the entry is in clock order.
+func (tr *Tracer) sent(channel string, msg message.Message) message.Message {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	msg.From = tr.GetID()
+	msg.Stamp()
+	if msg.Lamport == 0 && tr.clock != nil {
+		msg.Lamport = tr.clock.Tick()
+	}
+	tr.write(Entry{Channel: channel, Event: EventSend, Clock: msg.Lamport, Message: msg})
+	return msg
+}
+
+// received records msg with the clock after receiving it.
+func (tr *Tracer) received(msg message.Message) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	var now int64
+	if tr.clock != nil {
+		now = tr.clock.Now()
+	}
+	tr.write(Entry{Channel: msg.Channel, Event: EventReceive, Clock: now, Message: msg})
+}
+
+// write records e. Callers hold tr.mu.
+func (tr *Tracer) write(e Entry) {
+	e.Time = time.Now()
+	e.Node = tr.GetID()
+	if err := tr.rec.Write(e); err != nil {
+		fmt.Printf("Failed to trace message: %v\n", err)
+	}
+}
@@ -0,0 +1,116 @@
+package record
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/clock"
+	"Firetruck-sim/pkg/message"
+	"Firetruck-sim/pkg/transport"
+)
+
+// traced connects a traced node T1 and an untraced peer T2, whose clock is
+// well ahead so that every receive moves T1's clock forward.
+func traced(t *testing.T) (*Tracer, transport.Transport, *Recorder, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := transport.NewMemBus()
+	tr := NewTracer(transport.NewMemTransport("T1", bus), rec)
+	tr.SetClock(clock.NewLamportClock())
+	peer := transport.NewMemTransport("T2", bus)
+	peerClock := clock.NewLamportClock()
+	peerClock.Receive(1000)
+	peer.SetClock(peerClock)
+	return tr, peer, rec, path
+}
+
+func load(t *testing.T, rec *Recorder, path string) []Entry {
+	t.Helper()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestTracerSendsInClockOrder(t *testing.T) {
+	tr, peer, rec, path := traced(t)
+	defer tr.Close()
+	defer peer.Close()
+
+	const n = 200
+	var received sync.WaitGroup
+	received.Add(n)
+	if err := tr.Subscribe(transport.ChannelFireAlerts, func(msg message.Message) error {
+		received.Done()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sends race with receives that move the clock
+	var sent sync.WaitGroup
+	sent.Add(2)
+	go func() {
+		defer sent.Done()
+		for i := 0; i < n; i++ {
+			peer.Publish(transport.ChannelFireAlerts, message.Message{Type: message.TypeFireAlert})
+		}
+	}()
+	go func() {
+		defer sent.Done()
+		for i := 0; i < n; i++ {
+			tr.Publish(transport.ChannelTruckStatus, message.Message{Type: message.TypeTruckStatus})
+		}
+	}()
+	sent.Wait()
+	received.Wait()
+
+	report := Verify(load(t, rec, path))
+	if report.Sends != n || report.Receives != n {
+		t.Errorf("traced %d sends and %d receives, want %d each", report.Sends, report.Receives, n)
+	}
+	for _, v := range report.Violations {
+		t.Errorf("%s: %s", v.Kind, v.Detail)
+	}
+}
+
+func TestTracerReportsStaleTimestamps(t *testing.T) {
+	tr, peer, rec, path := traced(t)
+	defer tr.Close()
+	defer peer.Close()
+
+	received := make(chan message.Message, 1)
+	if err := tr.Subscribe(transport.ChannelFireAlerts, func(msg message.Message) error {
+		received <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Publish(transport.ChannelFireAlerts, message.Message{Type: message.TypeFireAlert}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("alert not delivered")
+	}
+
+	// Timestamps set by hand are recorded as they are
+	tr.Publish(transport.ChannelTruckStatus, message.Message{Type: message.TypeTruckStatus, Lamport: 1})
+	tr.Publish(transport.ChannelTruckStatus, message.Message{Type: message.TypeTruckStatus, Lamport: 5})
+
+	report := Verify(load(t, rec, path))
+	if report.Count(ViolationHardcoded) != 1 || report.Count(ViolationRegressed) != 1 {
+		t.Errorf("got violations %+v, want one hardcoded and one regressed", report.Violations)
+	}
+}
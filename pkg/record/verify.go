@@ -0,0 +1,156 @@
+package record
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Kinds of clock violations found by Verify.
+const (
+	// A node's clock after receiving a message was not past the send clock
+	ViolationReceive = "receive-not-after-send"
+	// A node's clock went backwards between two of its events
+	ViolationRegressed = "clock-regressed"
+	// A message was sent with Lamport 1 after the sender's clock had passed it
+	ViolationHardcoded = "hardcoded-timestamp"
+)
+
+// Violation is one breach of the happens-before properties of the clocks.
+type Violation struct {
+	Kind   string
+	Node   string
+	Detail string
+	Entry  Entry
+}
+
+// Report is the outcome of Verify.
+type Report struct {
+	Entries    int
+	Sends      int
+	Receives   int
+	Nodes      []string // nodes with traced events or recorded messages
+	Violations []Violation
+}
+
+// Count returns the number of violations of a kind.
+func (r Report) Count(kind string) int {
+	n := 0
+	for _, v := range r.Violations {
+		if v.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify checks the Lamport clocks in recorded entries. Entries written by
+// a Tracer give each node's events in order: every receive must leave the
+// clock past the message's timestamp and no event may move the clock
+// backwards. Entries recorded from the network only show messages, so
+// there each sender's timestamps are checked per channel in sequence order.
+// Sends stamped with Lamport 1 by a node whose clock had passed it are
+// reported as hardcoded rather than regressed.
+func Verify(entries []Entry) Report {
+	r := Report{Entries: len(entries)}
+	nodes := make(map[string]bool)
+
+	// Traced events, per node in the order they were written
+	last := make(map[string]int64)
+	for _, e := range entries {
+		if e.Event == "" {
+			continue
+		}
+		nodes[e.Node] = true
+		msg := e.Message
+
+		var ts int64
+		switch e.Event {
+		case EventSend:
+			r.Sends++
+			ts = msg.Lamport
+			if v, ok := checkSend(e.Node, ts, last[e.Node], e); ok {
+				r.Violations = append(r.Violations, v)
+			}
+		case EventReceive:
+			r.Receives++
+			ts = e.Clock
+			if ts == 0 {
+				// The node's clock was not traced
+				continue
+			}
+			if ts <= msg.Lamport {
+				r.Violations = append(r.Violations, Violation{
+					Kind:   ViolationReceive,
+					Node:   e.Node,
+					Detail: fmt.Sprintf("received %s from %s on %s stamped %d with clock %d", msg.Type, msg.From, e.Channel, msg.Lamport, ts),
+					Entry:  e,
+				})
+			}
+			if ts < last[e.Node] {
+				r.Violations = append(r.Violations, Violation{
+					Kind:   ViolationRegressed,
+					Node:   e.Node,
+					Detail: fmt.Sprintf("clock %d after receiving %s from %s, was %d", ts, msg.Type, msg.From, last[e.Node]),
+					Entry:  e,
+				})
+			}
+		default:
+			continue
+		}
+		last[e.Node] = max(last[e.Node], ts)
+	}
+
+	// Messages recorded from the network, per sender stream in sequence order
+	type stream struct {
+		from, channel string
+		epoch         int64
+	}
+	streams := make(map[stream][]Entry)
+	for _, e := range entries {
+		if e.Event != "" || e.Message.Seq == 0 {
+			continue
+		}
+		nodes[e.Message.From] = true
+		s := stream{e.Message.From, e.Channel, e.Message.Epoch}
+		streams[s] = append(streams[s], e)
+	}
+	for _, list := range streams {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Message.Seq < list[j].Message.Seq })
+		var prev int64
+		for _, e := range list {
+			if v, ok := checkSend(e.Message.From, e.Message.Lamport, prev, e); ok {
+				r.Violations = append(r.Violations, v)
+			}
+			prev = max(prev, e.Message.Lamport)
+		}
+	}
+
+	for node := range nodes {
+		r.Nodes = append(r.Nodes, node)
+	}
+	sort.Strings(r.Nodes)
+	return r
+}
+
+// checkSend checks the timestamp ts of a message sent by node whose clock
+// was at last.
+func checkSend(node string, ts, last int64, e Entry) (Violation, bool) {
+	msg := e.Message
+	switch {
+	case ts == 1 && last > 1:
+		return Violation{
+			Kind:   ViolationHardcoded,
+			Node:   node,
+			Detail: fmt.Sprintf("sent %s on %s with Lamport 1, clock was %d", msg.Type, e.Channel, last),
+			Entry:  e,
+		}, true
+	case ts < last:
+		return Violation{
+			Kind:   ViolationRegressed,
+			Node:   node,
+			Detail: fmt.Sprintf("sent %s on %s stamped %d, clock was %d", msg.Type, e.Channel, ts, last),
+			Entry:  e,
+		}, true
+	}
+	return Violation{}, false
+}
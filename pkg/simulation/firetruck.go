@@ -5,6 +5,8 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"Firetruck-sim/pkg/clock"
 	"Firetruck-sim/pkg/message"
@@ -17,12 +19,15 @@ type Firetruck struct {
 	Row, Col     int
 	Water        int
 	MaxWater     int
-	Clock        *clock.LamportClock
+	Clock        clock.Clock
 	Transport    transport.Transport
 	Task         string
 	AssignedFire *FireLocation
 
-	// Ricart-Agrawala state for water mutual exclusion
+	// Ricart-Agrawala state for water mutual exclusion. raMu guards it, as
+	// the RA handlers and the refill goroutine share it; messages are sent
+	// after releasing it.
+	raMu           sync.Mutex
 	ra             raState
 	myReqTS        int
 	replies        map[string]bool
@@ -31,6 +36,10 @@ type Firetruck struct {
 	lowWaterThresh int
 }
 
+// waterGrantTimeout bounds how long a truck in the critical section waits
+// for the water supply to answer
+const waterGrantTimeout = 2 * time.Second
+
 type raState int
 
 const (
@@ -39,6 +48,17 @@ const (
 	raHeld
 )
 
+func (s raState) String() string {
+	switch s {
+	case raRequesting:
+		return "requesting"
+	case raHeld:
+		return "held"
+	default:
+		return "idle"
+	}
+}
+
 // NewFiretruck creates a new firetruck at the given position
 func NewFiretruck(id string, r, c int) *Firetruck {
 	return &Firetruck{
@@ -107,9 +127,12 @@ func (t *Firetruck) MoveToward(targetR, targetC int) {
 
 	// Announce movement intention if transport is available and we actually moved
 	if t.Transport != nil && (oldRow != t.Row || oldCol != t.Col) {
-		t.AnnounceIntention("moving", targetR, targetC, map[string]interface{}{
-			"from_row": oldRow,
-			"from_col": oldCol,
+		t.AnnounceIntention(message.Coordination{
+			Action:    "moving",
+			TargetRow: targetR,
+			TargetCol: targetC,
+			FromRow:   &oldRow,
+			FromCol:   &oldCol,
 		})
 	}
 
@@ -175,11 +198,15 @@ func (t *Firetruck) BroadcastFireAlert(row, col, intensity int) {
 		return
 	}
 
-	msg := message.NewMessage(
-		message.TypeFireAlert,
-		t.ID,
-		message.FireAlertPayload(row, col, intensity),
-	)
+	msg, err := message.New(message.TypeFireAlert, t.ID, message.FireAlert{
+		Row:       row,
+		Col:       col,
+		Intensity: intensity,
+	})
+	if err != nil {
+		t.logf("failed to broadcast fire alert: %v", err)
+		return
+	}
 
 	if err := t.Transport.Publish(transport.ChannelFireAlerts, msg); err != nil {
 		t.logf("failed to broadcast fire alert: %v", err)
@@ -194,13 +221,17 @@ func (t *Firetruck) BroadcastStatus() {
 		return
 	}
 
-	msg := message.NewMessage(
-		message.TypeTruckStatus,
-		t.ID,
-		message.TruckStatusPayload(t.Row, t.Col, t.Water, t.MaxWater, t.Task),
-	)
-	msg.Lamport = t.Clock.Tick()
-
+	msg, err := message.New(message.TypeTruckStatus, t.ID, message.TruckStatus{
+		Row:      t.Row,
+		Col:      t.Col,
+		Water:    t.Water,
+		MaxWater: t.MaxWater,
+		Task:     t.Task,
+	})
+	if err != nil {
+		t.logf("failed to broadcast status: %v", err)
+		return
+	}
 	if err := t.Transport.Publish(transport.ChannelTruckStatus, msg); err != nil {
 		t.logf("failed to broadcast status: %v", err)
 	}
@@ -216,21 +247,21 @@ func (t *Firetruck) AddWater(amount int) {
 }
 
 // AnnounceIntention broadcasts coordination message about planned action
-func (t *Firetruck) AnnounceIntention(action string, targetRow, targetCol int, details map[string]interface{}) {
+func (t *Firetruck) AnnounceIntention(intent message.Coordination) {
 	if t.Transport == nil {
 		return
 	}
 
-	msg := message.NewMessage(
-		message.TypeCoordination,
-		t.ID,
-		message.CoordinationPayload(action, targetRow, targetCol, details),
-	)
+	msg, err := message.New(message.TypeCoordination, t.ID, intent)
+	if err != nil {
+		t.logf("failed to announce intention: %v", err)
+		return
+	}
 
 	if err := t.Transport.Publish(transport.ChannelCoordination, msg); err != nil {
 		t.logf("failed to announce intention: %v", err)
 	} else {
-		t.logf("announced intention: %s to (%d,%d)", action, targetRow, targetCol)
+		t.logf("announced intention: %s to (%d,%d)", intent.Action, intent.TargetRow, intent.TargetCol)
 	}
 }
 
@@ -242,13 +273,17 @@ func (t *Firetruck) BidForFire(fireRow, fireCol int) {
 
 	distance := abs(t.Row-fireRow) + abs(t.Col-fireCol) // Manhattan distance
 
-	msg := message.NewMessage(
-		message.TypeFireBid,
-		t.ID,
-		message.FireBidPayload(fireRow, fireCol, distance, t.Water, t.ID),
-	)
-	msg.Lamport = t.Clock.Tick()
-
+	msg, err := message.New(message.TypeFireBid, t.ID, message.FireBid{
+		FireRow:  fireRow,
+		FireCol:  fireCol,
+		Distance: distance,
+		Water:    t.Water,
+		TruckID:  t.ID,
+	})
+	if err != nil {
+		t.logf("failed to broadcast fire bid: %v", err)
+		return
+	}
 	if err := t.Transport.Publish(transport.ChannelFireBids, msg); err != nil {
 		t.logf("failed to broadcast fire bid: %v", err)
 	} else {
@@ -322,68 +357,142 @@ func abs(x int) int {
 func (t *Firetruck) StartRA() {
 	// Subscribe to RA channels
 	t.Transport.Subscribe(transport.ChannelWaterReq, t.handleWaterReq)
-	t.Transport.Subscribe(transport.ChannelWaterReply, t.handleWaterReply)
+	t.Transport.SubscribeInbox(t.handleWaterReply)
 	t.Transport.Subscribe(transport.ChannelWaterRelease, t.handleWaterRelease)
 	t.Transport.Subscribe(transport.ChannelTruckStatus, t.handleTruckStatus)
 }
 
+// SnapshotState returns the truck's position, water and Ricart-Agrawala
+// state for a global snapshot
+func (t *Firetruck) SnapshotState() map[string]interface{} {
+	t.raMu.Lock()
+	defer t.raMu.Unlock()
+	return map[string]interface{}{
+		"row":        t.Row,
+		"col":        t.Col,
+		"water":      t.Water,
+		"max_water":  t.MaxWater,
+		"ra":         t.ra.String(),
+		"request_ts": t.myReqTS,
+		"replies":    sortedKeys(t.replies),
+		"deferred":   sortedKeys(t.deferred),
+		"peers":      sortedKeys(t.peers),
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // handleTruckStatus discovers peers
 func (t *Firetruck) handleTruckStatus(msg message.Message) error {
 	if msg.From != t.ID {
+		t.raMu.Lock()
 		t.peers[msg.From] = true
+		t.raMu.Unlock()
 	}
 	return nil
 }
 
 // RequestWaterRA initiates Ricart-Agrawala protocol for water refill
 func (t *Firetruck) RequestWaterRA() {
+	t.raMu.Lock()
 	if t.ra != raIdle || t.Water > t.lowWaterThresh {
+		t.raMu.Unlock()
 		return
 	}
 
 	t.ra = raRequesting
 	t.myReqTS = int(t.Clock.Tick())
 	t.replies = make(map[string]bool)
+	ts := t.myReqTS
+	t.raMu.Unlock()
 
-	t.logf("[ME] REQUEST ts=%d", t.myReqTS)
+	t.logf("[ME] REQUEST ts=%d", ts)
+	t.publishWaterReq(ts)
+}
 
-	// Send request to all peers
-	req := message.Message{
-		Type:    message.TypeWaterReq,
-		From:    t.ID,
-		Lamport: int64(t.myReqTS),
-		Payload: map[string]interface{}{"ts": t.myReqTS},
+// ReannounceWaterRA repeats a pending water request with its original
+// timestamp, for peers that may have missed it while we were disconnected.
+// Peers that already replied simply reply again.
+func (t *Firetruck) ReannounceWaterRA() {
+	t.raMu.Lock()
+	requesting, ts := t.ra == raRequesting, t.myReqTS
+	t.raMu.Unlock()
+	if !requesting {
+		return
+	}
+	t.logf("[ME] REQUEST ts=%d (again)", ts)
+	t.publishWaterReq(ts)
+}
+
+// publishWaterReq sends our request with timestamp ts to all peers
+func (t *Firetruck) publishWaterReq(ts int) {
+	req, err := message.New(message.TypeWaterReq, t.ID, message.WaterReq{TS: ts})
+	if err != nil {
+		t.logf("[ME] failed to request: %v", err)
+		return
 	}
 	t.Transport.Publish(transport.ChannelWaterReq, req)
 }
 
 // handleWaterReq processes incoming water requests
 func (t *Firetruck) handleWaterReq(msg message.Message) error {
-	ts := int(msg.Payload["ts"].(float64))
+	// Our own broadcast request needs no reply
+	if msg.From == t.ID {
+		return nil
+	}
+	req, err := message.Decode[message.WaterReq](msg)
+	if err != nil {
+		return err
+	}
+	ts := req.TS
 
-	if t.ra == raHeld || (t.ra == raRequesting && (ts > t.myReqTS || (ts == t.myReqTS && msg.From > t.ID))) {
-		// Defer reply
+	t.raMu.Lock()
+	deferReply := t.ra == raHeld || (t.ra == raRequesting && (ts > t.myReqTS || (ts == t.myReqTS && msg.From > t.ID)))
+	if deferReply {
 		t.deferred[msg.From] = true
+	}
+	t.raMu.Unlock()
+
+	if deferReply {
 		t.logf("[ME] DEFER %s", msg.From)
 	} else {
-		// Reply immediately
-		reply := message.Message{
-			Type:    message.TypeWaterReply,
-			From:    t.ID,
-			Lamport: t.Clock.Tick(),
-		}
-		t.Transport.Publish(transport.ChannelWaterReply, reply)
+		// Reply immediately, only to the requester
+		t.sendWaterReply(msg.From)
 		t.logf("[ME] REPLY-> %s", msg.From)
 	}
 	return nil
 }
 
-// handleWaterReply processes replies
+// sendWaterReply grants a peer permission to enter the critical section
+func (t *Firetruck) sendWaterReply(peer string) {
+	reply := message.Message{
+		Type: message.TypeWaterReply,
+		From: t.ID,
+	}
+	if err := t.Transport.Send(peer, reply); err != nil {
+		t.logf("failed to reply to %s: %v", peer, err)
+	}
+}
+
+// handleWaterReply processes replies addressed to this truck
 func (t *Firetruck) handleWaterReply(msg message.Message) error {
+	if msg.Type != message.TypeWaterReply {
+		return nil
+	}
+
+	t.raMu.Lock()
+	allReplied := false
 	if t.ra == raRequesting {
 		t.replies[msg.From] = true
 		// Check if we have all replies
-		allReplied := true
+		allReplied = true
 		for peer := range t.peers {
 			if peer != t.ID && !t.replies[peer] {
 				allReplied = false
@@ -391,59 +500,85 @@ func (t *Firetruck) handleWaterReply(msg message.Message) error {
 			}
 		}
 		if allReplied {
-			t.enterCS()
+			t.ra = raHeld
 		}
 	}
+	t.raMu.Unlock()
+
+	if allReplied {
+		t.enterCS()
+	}
 	return nil
 }
 
 // handleWaterRelease processes releases
 func (t *Firetruck) handleWaterRelease(msg message.Message) error {
-	if t.deferred[msg.From] {
-		delete(t.deferred, msg.From)
-		reply := message.Message{
-			Type:    message.TypeWaterReply,
-			From:    t.ID,
-			Lamport: t.Clock.Tick(),
-		}
-		t.Transport.Publish(transport.ChannelWaterReply, reply)
+	t.raMu.Lock()
+	deferred := t.deferred[msg.From]
+	delete(t.deferred, msg.From)
+	t.raMu.Unlock()
+
+	if deferred {
+		t.sendWaterReply(msg.From)
 		t.logf("[ME] REPLY-> %s (deferred)", msg.From)
 	}
 	return nil
 }
 
-// enterCS enters the critical section (water refill)
+// enterCS runs the critical section (water refill) once the truck holds
+// it. The refill waits on the water supply, so it runs on its own
+// goroutine rather than holding up the inbox handler that granted the
+// last reply.
 func (t *Firetruck) enterCS() {
-	t.ra = raHeld
-	t.Water = t.MaxWater
 	t.logf("[ME] ENTER CS (refill)")
+	go func() {
+		t.refill()
+
+		// Exit CS immediately after refill
+		t.exitCS()
+	}()
+}
+
+// refill asks the water supply for enough water to fill the tank
+func (t *Firetruck) refill() {
+	req, err := message.New(message.TypeWaterRequest, t.ID, message.WaterRequest{Amount: t.MaxWater - t.Water})
+	if err != nil {
+		t.logf("[ME] failed to request water: %v", err)
+		return
+	}
+	resp, err := t.Transport.Request(transport.ChannelWaterSupply, req, waterGrantTimeout)
+	if err != nil {
+		t.logf("[ME] water supply unavailable: %v", err)
+		return
+	}
 
-	// Exit CS immediately after refill
-	t.exitCS()
+	granted, err := message.Decode[message.WaterResponse](resp)
+	if err != nil {
+		t.logf("[ME] %v", err)
+		return
+	}
+	t.AddWater(granted.Amount)
 }
 
 // exitCS exits the critical section
 func (t *Firetruck) exitCS() {
+	t.raMu.Lock()
 	t.ra = raIdle
+	deferred := sortedKeys(t.deferred)
+	t.deferred = make(map[string]bool)
+	t.raMu.Unlock()
 
 	// Send release to all peers
 	release := message.Message{
-		Type:    message.TypeWaterRelease,
-		From:    t.ID,
-		Lamport: t.Clock.Tick(),
+		Type: message.TypeWaterRelease,
+		From: t.ID,
 	}
 	t.Transport.Publish(transport.ChannelWaterRelease, release)
 	t.logf("[ME] RELEASE")
 
 	// Reply to all deferred requests
-	for peer := range t.deferred {
-		delete(t.deferred, peer)
-		reply := message.Message{
-			Type:    message.TypeWaterReply,
-			From:    t.ID,
-			Lamport: t.Clock.Tick(),
-		}
-		t.Transport.Publish(transport.ChannelWaterReply, reply)
+	for _, peer := range deferred {
+		t.sendWaterReply(peer)
 		t.logf("[ME] REPLY-> %s (deferred)", peer)
 	}
 }
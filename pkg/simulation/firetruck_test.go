@@ -0,0 +1,111 @@
+package simulation
+
+import (
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+	"Firetruck-sim/pkg/transport"
+)
+
+// TestRefillWhilePeersRequest runs a truck through the critical section
+// while a peer keeps asking for it, so that the race detector sees the RA
+// handlers and the refill goroutine share the truck's state.
+func TestRefillWhilePeersRequest(t *testing.T) {
+	bus := transport.NewMemBus()
+	truck := NewFiretruck("T1", 0, 0)
+	truck.Water = 5
+	truck.SetTransport(transport.NewMemTransport("T1", bus))
+	defer truck.Transport.Close()
+	truck.StartRA()
+
+	peer := transport.NewMemTransport("T2", bus)
+	defer peer.Close()
+	granted := make(chan message.Message, 64)
+	if err := peer.SubscribeInbox(func(msg message.Message) error {
+		granted <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan message.Message, 1)
+	if err := peer.Subscribe(transport.ChannelWaterRelease, func(msg message.Message) error {
+		released <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The water supply takes its time, as a remote one would
+	supply := transport.NewMemTransport("WATER-SUPPLY", bus)
+	defer supply.Close()
+	if err := supply.Subscribe(transport.ChannelWaterSupply, func(req message.Message) error {
+		time.Sleep(50 * time.Millisecond)
+		resp, err := message.New(message.TypeWaterResponse, "WATER-SUPPLY", message.WaterResponse{Amount: 45})
+		if err != nil {
+			return err
+		}
+		return supply.Reply(req, resp)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := peer.Publish(transport.ChannelTruckStatus, message.Message{Type: message.TypeTruckStatus}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "T1 to discover T2", func() bool {
+		_, _, peers := readRA(truck)
+		return peers == 1
+	})
+
+	truck.RequestWaterRA()
+	if err := peer.Send("T1", message.Message{Type: message.TypeWaterReply}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep requesting with later timestamps while T1 holds the section
+	const requests = 20
+	for i := 0; i < requests; i++ {
+		req, err := message.New(message.TypeWaterReq, "T2", message.WaterReq{TS: 1000 + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := peer.Publish(transport.ChannelWaterReq, req); err != nil {
+			t.Fatal(err)
+		}
+		readRA(truck)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	select {
+	case <-released:
+	case <-time.After(2 * time.Second):
+		t.Fatal("T1 never released the critical section")
+	}
+	// Requests deferred while T1 held the section are answered on release
+	waitFor(t, "T1 to grant T2's requests", func() bool {
+		return len(granted) > 0
+	})
+	if ra, deferred, _ := readRA(truck); ra != raIdle || deferred != 0 {
+		t.Errorf("after release ra=%v with %d deferred, want idle with none deferred", ra, deferred)
+	}
+}
+
+// readRA reads the truck's RA state the way its handlers do. The truck's
+// position and water belong to the simulation loop and are not read here.
+func readRA(truck *Firetruck) (ra raState, deferred, peers int) {
+	truck.raMu.Lock()
+	defer truck.raMu.Unlock()
+	return truck.ra, len(truck.deferred), len(truck.peers)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
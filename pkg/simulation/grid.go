@@ -26,6 +26,17 @@ const (
 	Extinguished
 )
 
+func (s CellState) String() string {
+	switch s {
+	case Fire:
+		return "fire"
+	case Extinguished:
+		return "extinguished"
+	default:
+		return "empty"
+	}
+}
+
 type Cell struct {
 	State     CellState
 	Intensity int
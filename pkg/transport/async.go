@@ -0,0 +1,65 @@
+package transport
+
+import "sync"
+
+// asyncSub queues raw messages for one subscription and hands them to
+// deliver on a dedicated goroutine, one at a time, like a NATS async
+// subscriber. The subject may contain wildcards; deliver gets the subject
+// each message was published on.
+type asyncSub struct {
+	subject string
+	deliver func(subject string, data []byte)
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []rawMsg
+	closed bool
+}
+
+type rawMsg struct {
+	subject string
+	data    []byte
+}
+
+// newAsyncSub creates a subscription and starts its delivery goroutine.
+func newAsyncSub(subject string, deliver func(subject string, data []byte)) *asyncSub {
+	s := &asyncSub{subject: subject, deliver: deliver}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func (s *asyncSub) push(subject string, data []byte) {
+	s.mu.Lock()
+	if !s.closed {
+		s.queue = append(s.queue, rawMsg{subject, data})
+		s.cond.Signal()
+	}
+	s.mu.Unlock()
+}
+
+func (s *asyncSub) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		m := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		s.deliver(m.subject, m.data)
+	}
+}
+
+func (s *asyncSub) stop() {
+	s.mu.Lock()
+	s.closed = true
+	s.queue = nil
+	s.cond.Signal()
+	s.mu.Unlock()
+}
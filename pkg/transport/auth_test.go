@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// waitTime bounds how long tests wait for an asynchronous delivery.
+const waitTime = 2 * time.Second
+
+func testKeyring() *Keyring {
+	return NewKeyring(map[string]string{"T1": "s1", "T2": "s2", "T3": "s3"})
+}
+
+// collect subscribes to channel and returns the messages it receives.
+func collect(t *testing.T, tr Transport, channel string) <-chan message.Message {
+	t.Helper()
+	got := make(chan message.Message, 16)
+	if err := tr.Subscribe(channel, func(msg message.Message) error {
+		got <- msg
+		return nil
+	}); err != nil {
+		t.Fatalf("subscribe %s: %v", channel, err)
+	}
+	return got
+}
+
+func receive(t *testing.T, got <-chan message.Message) message.Message {
+	t.Helper()
+	select {
+	case msg := <-got:
+		return msg
+	case <-time.After(waitTime):
+		t.Fatal("no message received")
+		return message.Message{}
+	}
+}
+
+func expectNone(t *testing.T, got <-chan message.Message) {
+	t.Helper()
+	select {
+	case msg := <-got:
+		t.Fatalf("unexpected %s from %s on %s", msg.Type, msg.From, msg.Channel)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSignedMessageDelivered(t *testing.T) {
+	bus := NewMemBus()
+	t1 := NewMemTransport("T1", bus, WithKeyring(testKeyring()))
+	t2 := NewMemTransport("T2", bus, WithKeyring(testKeyring()))
+	defer t1.Close()
+	defer t2.Close()
+
+	got := collect(t, t2, ChannelFireBids)
+	if err := t1.Publish(ChannelFireBids, message.Message{Type: message.TypeBid}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := receive(t, got)
+	if msg.From != "T1" || msg.To != ChannelFireBids {
+		t.Errorf("got message from %s to %s, want from T1 to %s", msg.From, msg.To, ChannelFireBids)
+	}
+	if n := t2.AuthFailures(); n != 0 {
+		t.Errorf("AuthFailures = %d, want 0", n)
+	}
+}
+
+func TestRogueNodeRejected(t *testing.T) {
+	bus := NewMemBus()
+	honest := NewMemTransport("T1", bus, WithKeyring(testKeyring()))
+	defer honest.Close()
+
+	// The rogue claims to be T3 but does not hold its secret
+	rogue := NewMemTransport("T3", bus)
+	defer rogue.Close()
+	forger := NewMemTransport("T3", bus, WithKeyring(NewKeyring(map[string]string{"T3": "guessed"})))
+	defer forger.Close()
+
+	bids := collect(t, honest, ChannelFireBids)
+	inbox := make(chan message.Message, 16)
+	if err := honest.SubscribeInbox(func(msg message.Message) error {
+		inbox <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rogue.Publish(ChannelFireBids, message.Message{Type: message.TypeBid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rogue.Send("T1", message.Message{Type: message.TypeWaterReply}); err != nil {
+		t.Fatal(err)
+	}
+	if err := forger.Publish(ChannelFireBids, message.Message{Type: message.TypeBid}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectNone(t, bids)
+	expectNone(t, inbox)
+	if n := honest.AuthFailures(); n != 3 {
+		t.Errorf("AuthFailures = %d, want 3", n)
+	}
+}
+
+func TestRedirectedMessageRejected(t *testing.T) {
+	bus := NewMemBus()
+	t1 := NewMemTransport("T1", bus, WithKeyring(testKeyring()))
+	t3 := NewMemTransport("T3", bus, WithKeyring(testKeyring()))
+	defer t1.Close()
+	defer t3.Close()
+
+	// A node without a key listens in on T2's inbox
+	rogue := NewMemTransport("X", bus)
+	defer rogue.Close()
+	captured := collect(t, rogue, InboxChannel("T2"))
+	bids := collect(t, t3, ChannelFireBids)
+	inbox := make(chan message.Message, 16)
+	if err := t3.SubscribeInbox(func(msg message.Message) error {
+		inbox <- msg
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := t1.Send("T2", message.Message{Type: message.TypeWaterReply}); err != nil {
+		t.Fatal(err)
+	}
+	reply := receive(t, captured)
+
+	// Replayed unchanged, signature and all, to T3 and onto a broadcast channel
+	if err := rogue.Republish(InboxChannel("T3"), reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := rogue.Republish(ChannelFireBids, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	expectNone(t, inbox)
+	expectNone(t, bids)
+	if n := t3.AuthFailures(); n != 2 {
+		t.Errorf("AuthFailures = %d, want 2", n)
+	}
+}
+
+func TestSignedReply(t *testing.T) {
+	bus := NewMemBus()
+	supply := NewMemTransport("T2", bus, WithKeyring(testKeyring()))
+	truck := NewMemTransport("T1", bus, WithKeyring(testKeyring()))
+	defer supply.Close()
+	defer truck.Close()
+
+	if err := supply.Subscribe(ChannelWaterSupply, func(req message.Message) error {
+		return supply.Reply(req, message.Message{Type: message.TypeWaterResponse})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := truck.Request(ChannelWaterSupply, message.Message{Type: message.TypeWaterRequest}, waitTime)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if resp.Type != message.TypeWaterResponse || resp.From != "T2" {
+		t.Errorf("got %s from %s, want %s from T2", resp.Type, resp.From, message.TypeWaterResponse)
+	}
+	if n := truck.AuthFailures() + supply.AuthFailures(); n != 0 {
+		t.Errorf("AuthFailures = %d, want 0", n)
+	}
+}
@@ -0,0 +1,483 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"Firetruck-sim/pkg/clock"
+	"Firetruck-sim/pkg/message"
+)
+
+// binaryMagic starts every BinaryCodec message. JSON messages start with
+// '{', so the first byte tells the codecs apart.
+const (
+	binaryMagic   = 0xB1
+	binaryVersion = 1
+)
+
+// Message fields, each written as tag, length and value. Fields with their
+// zero value are left out, and readers skip tags they do not know.
+const (
+	fieldVersion = iota + 1
+	fieldType
+	fieldFrom
+	fieldLamport
+	fieldPayload
+	fieldID
+	fieldCorrelation // empty means the same as the ID
+	fieldTrace
+	fieldParent
+	fieldExpires
+	fieldSeq
+	fieldEpoch
+	fieldVector
+	fieldCausal
+	fieldSnapshot
+	fieldReplyTo
+	fieldSignature
+	fieldTo
+
+	// packedHex marks a string field written as the bytes its lowercase
+	// hex stands for, such as a trace or signature
+	packedHex = 0x40
+)
+
+// Kinds of payload values.
+const (
+	kindNull = iota
+	kindFalse
+	kindTrue
+	kindInt    // zigzag varint
+	kindUint   // varint, for values beyond int64
+	kindFloat  // IEEE 754 bits, little endian
+	kindString // length and bytes
+	kindMap    // count, then key and value of each entry by key
+	kindList   // count, then the values
+)
+
+// BinaryCodec encodes messages compactly: varints instead of decimal
+// numbers, hex fields as raw bytes and no field names outside the payload.
+// Whole numbers in payloads stay integers instead of becoming float64.
+//
+// The signature of a binary message is checked against its decoded form,
+// so fields a reader does not know are not covered by it; keep JSON on
+// signed runs while upgrading nodes.
+type BinaryCodec struct{}
+
+// Name returns "binary".
+func (BinaryCodec) Name() string { return "binary" }
+
+// Marshal encodes msg in the binary format.
+func (BinaryCodec) Marshal(msg message.Message) ([]byte, error) {
+	w := binaryWriter{buf: []byte{binaryMagic, binaryVersion}}
+	w.uint(fieldVersion, uint64(msg.Version))
+	w.text(fieldType, msg.Type)
+	w.text(fieldFrom, msg.From)
+	w.int(fieldLamport, msg.Lamport)
+	if msg.Payload != nil {
+		value, err := appendValue(nil, msg.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload: %w", err)
+		}
+		w.field(fieldPayload, value)
+	}
+	w.text(fieldID, msg.ID)
+	if msg.Correlation != "" && msg.Correlation == msg.ID {
+		w.field(fieldCorrelation, nil)
+	} else {
+		w.text(fieldCorrelation, msg.Correlation)
+	}
+	w.text(fieldTrace, msg.Trace)
+	w.text(fieldParent, msg.Parent)
+	w.int(fieldExpires, msg.Expires)
+	w.uint(fieldSeq, msg.Seq)
+	w.int(fieldEpoch, msg.Epoch)
+	w.vector(fieldVector, msg.Vector)
+	w.vector(fieldCausal, msg.Causal)
+	w.uint(fieldSnapshot, msg.Snapshot)
+	w.text(fieldReplyTo, msg.ReplyTo)
+	w.text(fieldSignature, msg.Signature)
+	w.text(fieldTo, msg.To)
+	return w.buf, nil
+}
+
+// Unmarshal decodes a binary message. Payload numbers become int64, or
+// uint64 or float64 where they do not fit.
+func (BinaryCodec) Unmarshal(data []byte, msg *message.Message) error {
+	if len(data) < 2 || data[0] != binaryMagic {
+		return errors.New("not a binary message")
+	}
+	if data[1] != binaryVersion {
+		return fmt.Errorf("unsupported binary format %d", data[1])
+	}
+	*msg = message.Message{}
+	r := binaryReader{data: data[2:]}
+	sameCorrelation := false
+	for len(r.data) > 0 {
+		tag, err := r.uvarint()
+		if err != nil {
+			return err
+		}
+		value, err := r.bytes()
+		if err != nil {
+			return fmt.Errorf("field %d: %w", tag, err)
+		}
+		if err := decodeField(msg, tag, value, &sameCorrelation); err != nil {
+			return fmt.Errorf("field %d: %w", tag, err)
+		}
+	}
+	if sameCorrelation {
+		msg.Correlation = msg.ID
+	}
+	return nil
+}
+
+// decodeField sets the field tag of msg from its encoded value.
+func decodeField(msg *message.Message, tag uint64, value []byte, sameCorrelation *bool) error {
+	if tag&packedHex != 0 {
+		s := hex.EncodeToString(value)
+		tag &^= packedHex
+		value = []byte(s)
+	}
+	v := binaryReader{data: value}
+	var err error
+	switch tag {
+	case fieldVersion:
+		var n uint64
+		n, err = v.uvarint()
+		msg.Version = int(n)
+	case fieldType:
+		msg.Type = string(value)
+	case fieldFrom:
+		msg.From = string(value)
+	case fieldLamport:
+		msg.Lamport, err = v.varint()
+	case fieldPayload:
+		var p interface{}
+		if p, err = v.value(); err == nil {
+			var ok bool
+			if msg.Payload, ok = p.(map[string]interface{}); !ok {
+				err = errors.New("payload is not a map")
+			}
+		}
+	case fieldID:
+		msg.ID = string(value)
+	case fieldCorrelation:
+		msg.Correlation = string(value)
+		*sameCorrelation = len(value) == 0
+	case fieldTrace:
+		msg.Trace = string(value)
+	case fieldParent:
+		msg.Parent = string(value)
+	case fieldExpires:
+		msg.Expires, err = v.varint()
+	case fieldSeq:
+		msg.Seq, err = v.uvarint()
+	case fieldEpoch:
+		msg.Epoch, err = v.varint()
+	case fieldVector:
+		msg.Vector, err = v.vector()
+	case fieldCausal:
+		msg.Causal, err = v.vector()
+	case fieldSnapshot:
+		msg.Snapshot, err = v.uvarint()
+	case fieldReplyTo:
+		msg.ReplyTo = string(value)
+	case fieldSignature:
+		msg.Signature = string(value)
+	case fieldTo:
+		msg.To = string(value)
+	default:
+		// Written by a newer version
+	}
+	return err
+}
+
+// binaryWriter appends message fields to buf.
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) field(tag int, value []byte) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(tag))
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(value)))
+	w.buf = append(w.buf, value...)
+}
+
+// text writes a string field, packed if it is lowercase hex.
+func (w *binaryWriter) text(tag int, s string) {
+	if s == "" {
+		return
+	}
+	if len(s)%2 == 0 {
+		if b, err := hex.DecodeString(s); err == nil && hex.EncodeToString(b) == s {
+			w.field(tag|packedHex, b)
+			return
+		}
+	}
+	w.field(tag, []byte(s))
+}
+
+func (w *binaryWriter) int(tag int, v int64) {
+	if v != 0 {
+		w.field(tag, binary.AppendVarint(nil, v))
+	}
+}
+
+func (w *binaryWriter) uint(tag int, v uint64) {
+	if v != 0 {
+		w.field(tag, binary.AppendUvarint(nil, v))
+	}
+}
+
+// vector writes a vector timestamp as its entries sorted by node.
+func (w *binaryWriter) vector(tag int, v clock.Vector) {
+	if len(v) == 0 {
+		return
+	}
+	nodes := make([]string, 0, len(v))
+	for node := range v {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	buf := binary.AppendUvarint(nil, uint64(len(nodes)))
+	for _, node := range nodes {
+		buf = appendString(buf, node)
+		buf = binary.AppendUvarint(buf, v[node])
+	}
+	w.field(tag, buf)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendValue appends a payload value. Payloads hold JSON values, decoded
+// with or without UseNumber; other values go through their JSON encoding.
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, kindNull), nil
+	case bool:
+		if v {
+			return append(buf, kindTrue), nil
+		}
+		return append(buf, kindFalse), nil
+	case int:
+		return appendInt(buf, int64(v)), nil
+	case int64:
+		return appendInt(buf, v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return binary.AppendUvarint(append(buf, kindUint), v), nil
+		}
+		return appendInt(buf, int64(v)), nil
+	case float64:
+		return appendFloat(buf, v), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendInt(buf, n), nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return binary.AppendUvarint(append(buf, kindUint), n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendFloat(buf, f), nil
+	case string:
+		return appendString(append(buf, kindString), v), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = binary.AppendUvarint(append(buf, kindMap), uint64(len(keys)))
+		for _, k := range keys {
+			buf = appendString(buf, k)
+			var err error
+			if buf, err = appendValue(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case []interface{}:
+		buf = binary.AppendUvarint(append(buf, kindList), uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var generic interface{}
+		if err := dec.Decode(&generic); err != nil {
+			return nil, err
+		}
+		return appendValue(buf, generic)
+	}
+}
+
+func appendInt(buf []byte, v int64) []byte {
+	return binary.AppendVarint(append(buf, kindInt), v)
+}
+
+// appendFloat writes whole numbers as integers, which encode to the same
+// JSON. Negative zero does not, and stays a float.
+func appendFloat(buf []byte, v float64) []byte {
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 && !(v == 0 && math.Signbit(v)) {
+		return appendInt(buf, int64(v))
+	}
+	buf = append(buf, kindFloat)
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+}
+
+// errTruncated reports a binary message that ends inside a field.
+var errTruncated = errors.New("truncated binary message")
+
+// binaryReader consumes encoded fields and values from data.
+type binaryReader struct {
+	data []byte
+}
+
+func (r *binaryReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+func (r *binaryReader) varint() (int64, error) {
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// bytes reads a length and that many bytes.
+func (r *binaryReader) bytes() ([]byte, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)) {
+		return nil, errTruncated
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+// count reads the number of entries of a collection, each taking at least
+// one byte, so that a corrupt count cannot make the reader allocate more
+// than the message holds.
+func (r *binaryReader) count() (int, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.data)) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (r *binaryReader) vector() (clock.Vector, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	v := make(clock.Vector, n)
+	for i := 0; i < n; i++ {
+		node, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if v[string(node)], err = r.uvarint(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// value reads a payload value.
+func (r *binaryReader) value() (interface{}, error) {
+	if len(r.data) == 0 {
+		return nil, errTruncated
+	}
+	kind := r.data[0]
+	r.data = r.data[1:]
+	switch kind {
+	case kindNull:
+		return nil, nil
+	case kindFalse:
+		return false, nil
+	case kindTrue:
+		return true, nil
+	case kindInt:
+		return r.varint()
+	case kindUint:
+		return r.uvarint()
+	case kindFloat:
+		if len(r.data) < 8 {
+			return nil, errTruncated
+		}
+		bits := binary.LittleEndian.Uint64(r.data)
+		r.data = r.data[8:]
+		return math.Float64frombits(bits), nil
+	case kindString:
+		s, err := r.bytes()
+		return string(s), err
+	case kindMap:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			if m[string(key)], err = r.value(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case kindList:
+		n, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = r.value(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unknown value kind %d", kind)
+	}
+}
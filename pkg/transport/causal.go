@@ -0,0 +1,289 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"Firetruck-sim/pkg/clock"
+	"Firetruck-sim/pkg/message"
+)
+
+// causalWait bounds how long a message waits for its causal predecessors
+// before it is delivered anyway, since a lost message would otherwise hold
+// back everything after it.
+const causalWait = 5 * time.Second
+
+// CausalInbox names the node's inbox in the channel list of
+// NewCausalTransport.
+const CausalInbox = "inbox"
+
+// CausalStats describes the causal delivery buffer.
+type CausalStats struct {
+	Buffered    int    // messages waiting for predecessors right now
+	MaxBuffered int    // most messages ever waiting at once
+	Delayed     uint64 // messages that had to wait
+	Forced      uint64 // messages delivered after giving up on a predecessor
+}
+
+// CausalTransport wraps a Transport and delivers messages on selected
+// channels in causal order: a message reaches handlers only after every
+// message its sender had delivered before sending it, on any of those
+// channels.
+//
+// Broadcasts carry a vector with the number of causal broadcasts delivered
+// from each node, counting the new one (Birman–Schiper–Stephenson). Direct
+// messages carry the same vector without counting themselves, so they wait
+// for the broadcasts they depend on but not for each other. Every node
+// should use the same channel list.
+type CausalTransport struct {
+	Transport
+
+	channels map[string]bool
+	inbox    bool
+
+	mu        sync.Mutex
+	delivered clock.Vector // causal broadcasts delivered per sender
+	pending   []*causalMsg
+	outbox    []*causalMsg
+	handlers  map[string][]SubscriptionHandler
+	stats     CausalStats
+
+	dispatchMu sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+type causalMsg struct {
+	msg     message.Message
+	direct  bool
+	arrived time.Time
+}
+
+// NewCausalTransport wraps inner with causal delivery on channels. Include
+// CausalInbox to order direct messages as well.
+func NewCausalTransport(inner Transport, channels []string) (*CausalTransport, error) {
+	ct := &CausalTransport{
+		Transport: inner,
+		channels:  make(map[string]bool),
+		delivered: make(clock.Vector),
+		handlers:  make(map[string][]SubscriptionHandler),
+		done:      make(chan struct{}),
+	}
+
+	// Listen on every causal channel, handlers or not, so that no
+	// predecessor is ever missed
+	for _, ch := range channels {
+		if ch == CausalInbox {
+			ct.inbox = true
+			if err := inner.SubscribeInbox(ct.receive); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ct.channels[ch] = true
+		if err := inner.Subscribe(ch, ct.receive); err != nil {
+			return nil, err
+		}
+	}
+
+	go ct.expire()
+	return ct, nil
+}
+
+// Publish stamps messages on causal channels with their dependencies.
+func (ct *CausalTransport) Publish(channel string, msg message.Message) error {
+	if ct.channels[channel] {
+		ct.mu.Lock()
+		ct.delivered[ct.GetID()]++
+		msg.Causal = ct.delivered.Copy()
+		ct.mu.Unlock()
+	}
+	return ct.Transport.Publish(channel, msg)
+}
+
+// Send stamps direct messages with their dependencies when the inbox is
+// causal.
+func (ct *CausalTransport) Send(to string, msg message.Message) error {
+	if ct.inbox {
+		ct.mu.Lock()
+		msg.Causal = ct.delivered.Copy()
+		ct.mu.Unlock()
+	}
+	return ct.Transport.Send(to, msg)
+}
+
+// Subscribe registers handler for causal delivery on causal channels and
+// passes other channels through.
+func (ct *CausalTransport) Subscribe(channel string, handler SubscriptionHandler) error {
+	if !ct.channels[channel] {
+		return ct.Transport.Subscribe(channel, handler)
+	}
+	ct.mu.Lock()
+	ct.handlers[channel] = append(ct.handlers[channel], handler)
+	ct.mu.Unlock()
+	return nil
+}
+
+// SubscribeInbox registers an inbox handler, in causal order if the inbox
+// is causal.
+func (ct *CausalTransport) SubscribeInbox(handler SubscriptionHandler) error {
+	if !ct.inbox {
+		return ct.Transport.SubscribeInbox(handler)
+	}
+	ch := InboxChannel(ct.GetID())
+	ct.mu.Lock()
+	ct.handlers[ch] = append(ct.handlers[ch], handler)
+	ct.mu.Unlock()
+	return nil
+}
+
+// CausalStats returns the state of the causal delivery buffer.
+func (ct *CausalTransport) CausalStats() CausalStats {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	stats := ct.stats
+	stats.Buffered = len(ct.pending)
+	return stats
+}
+
+// Close stops the delivery buffer and closes the inner transport.
+func (ct *CausalTransport) Close() error {
+	ct.closeOnce.Do(func() { close(ct.done) })
+	return ct.Transport.Close()
+}
+
+// receive buffers a message until it is causally ready.
+func (ct *CausalTransport) receive(msg message.Message) error {
+	m := &causalMsg{
+		msg:     msg,
+		direct:  msg.Channel == InboxChannel(ct.GetID()),
+		arrived: time.Now(),
+	}
+
+	ct.mu.Lock()
+	if msg.Causal == nil || msg.From == ct.GetID() {
+		// Unstamped, or our own broadcast, which is counted when sent
+		ct.outbox = append(ct.outbox, m)
+	} else {
+		if _, known := ct.delivered[msg.From]; !known && !m.direct {
+			// First message from a node that was running before we joined;
+			// its earlier broadcasts are gone
+			ct.delivered[msg.From] = msg.Causal[msg.From] - 1
+		}
+		ct.pending = append(ct.pending, m)
+		if !ct.readyLocked(m) {
+			ct.stats.Delayed++
+		}
+		ct.stats.MaxBuffered = max(ct.stats.MaxBuffered, len(ct.pending))
+		ct.releaseLocked()
+	}
+	ct.mu.Unlock()
+
+	ct.dispatch()
+	return nil
+}
+
+// readyLocked reports whether every predecessor of m has been delivered.
+// Callers hold ct.mu.
+func (ct *CausalTransport) readyLocked(m *causalMsg) bool {
+	for id, n := range m.msg.Causal {
+		need := ct.delivered[id]
+		if id == m.msg.From && !m.direct {
+			// A broadcast is next in line from its sender, or older
+			need++
+		}
+		if n > need {
+			return false
+		}
+	}
+	return true
+}
+
+// releaseLocked moves every ready message to the outbox, in arrival order.
+// Callers hold ct.mu.
+func (ct *CausalTransport) releaseLocked() {
+	for progress := true; progress; {
+		progress = false
+		for i, m := range ct.pending {
+			if !ct.readyLocked(m) {
+				continue
+			}
+			ct.deliverLocked(m)
+			ct.pending = append(ct.pending[:i:i], ct.pending[i+1:]...)
+			progress = true
+			break
+		}
+	}
+}
+
+// deliverLocked counts m as delivered and queues it for its handlers.
+// Callers hold ct.mu.
+func (ct *CausalTransport) deliverLocked(m *causalMsg) {
+	if !m.direct && m.msg.Causal[m.msg.From] > ct.delivered[m.msg.From] {
+		ct.delivered[m.msg.From] = m.msg.Causal[m.msg.From]
+	}
+	ct.outbox = append(ct.outbox, m)
+}
+
+// dispatch runs handlers for queued messages, one at a time and in order.
+func (ct *CausalTransport) dispatch() {
+	ct.dispatchMu.Lock()
+	defer ct.dispatchMu.Unlock()
+
+	for {
+		ct.mu.Lock()
+		if len(ct.outbox) == 0 {
+			ct.mu.Unlock()
+			return
+		}
+		m := ct.outbox[0]
+		ct.outbox = ct.outbox[1:]
+		handlers := ct.handlers[m.msg.Channel]
+		ct.mu.Unlock()
+
+		for _, h := range handlers {
+			if err := h(m.msg); err != nil {
+				fmt.Printf("Error handling broadcast message: %v\n", err)
+			}
+		}
+	}
+}
+
+// expire gives up on predecessors of messages that waited too long.
+func (ct *CausalTransport) expire() {
+	ticker := time.NewTicker(causalWait / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ct.done:
+			return
+		case <-ticker.C:
+		}
+
+		ct.mu.Lock()
+		forced := false
+		for len(ct.pending) > 0 && time.Since(ct.pending[0].arrived) > causalWait {
+			// Pretend the missing predecessors arrived
+			m := ct.pending[0]
+			for id, n := range m.msg.Causal {
+				if id == m.msg.From && !m.direct {
+					n--
+				}
+				if n > ct.delivered[id] {
+					ct.delivered[id] = n
+				}
+			}
+			ct.stats.Forced++
+			ct.releaseLocked()
+			forced = true
+		}
+		ct.mu.Unlock()
+
+		if forced {
+			ct.dispatch()
+		}
+	}
+}
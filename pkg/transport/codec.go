@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// Codec encodes messages for the wire. The first byte of every encoding
+// tells which codec wrote it, so a node decodes messages from any codec
+// whichever one it sends with; see WithCodec.
+type Codec interface {
+	// Name identifies the codec on the command line
+	Name() string
+	Marshal(msg message.Message) ([]byte, error)
+	Unmarshal(data []byte, msg *message.Message) error
+}
+
+// Codecs lists the available codecs, JSON first.
+var Codecs = []Codec{JSONCodec{}, BinaryCodec{}}
+
+// WithCodec sends messages encoded with c instead of JSON.
+func WithCodec(c Codec) Option {
+	return func(e *endpoint) {
+		e.codec = c
+	}
+}
+
+// ParseCodec returns the codec with the given name.
+func ParseCodec(name string) (Codec, error) {
+	var names []string
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+		names = append(names, c.Name())
+	}
+	return nil, fmt.Errorf("unknown codec %q (want %s)", name, strings.Join(names, " or "))
+}
+
+// codecOf returns the codec that encoded data.
+func codecOf(data []byte) (Codec, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty message")
+	}
+	if data[0] == binaryMagic {
+		return BinaryCodec{}, nil
+	}
+	return JSONCodec{}, nil
+}
+
+// isJSON tells whether data is a JSON encoded message.
+func isJSON(data []byte) bool {
+	c, err := codecOf(data)
+	return err == nil && c.Name() == "json"
+}
+
+// JSONCodec encodes messages as JSON objects, starting with '{'.
+type JSONCodec struct{}
+
+// Name returns "json".
+func (JSONCodec) Name() string { return "json" }
+
+// Marshal encodes msg as JSON.
+func (JSONCodec) Marshal(msg message.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// Unmarshal decodes a JSON message. Payload numbers become float64.
+func (JSONCodec) Unmarshal(data []byte, msg *message.Message) error {
+	return json.Unmarshal(data, msg)
+}
@@ -0,0 +1,330 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/clock"
+	"Firetruck-sim/pkg/message"
+)
+
+// codecSample is a message as a node puts it on the wire.
+type codecSample struct {
+	name string
+	msg  message.Message
+}
+
+// codecSamples builds the messages a run sends most: a truck status
+// heartbeat, a fire bid and a stats report, stamped like the transport
+// stamps them, and a heartbeat carrying a vector clock.
+func codecSamples(tb testing.TB, keyring *Keyring) []codecSample {
+	tb.Helper()
+	status, err := message.New(message.TypeTruckStatus, "T1", message.TruckStatus{
+		Row: 12, Col: 7, Water: 35, MaxWater: 50, Task: "moving to fire at (14,9)",
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	bid, err := message.New(message.TypeFireBid, "T2", message.FireBid{
+		TruckID: "T2", FireRow: 14, FireCol: 9, Distance: 4, Water: 42,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stats, err := message.New(message.TypeStatsReport, "T3", message.StatsReport{
+		Streams: []message.StreamStats{
+			{Peer: "T1", Channel: ChannelTruckStatus, Received: 4480, Gaps: 3},
+			{Peer: "T2", Channel: ChannelTruckStatus, Received: 4475, Duplicates: 12},
+			{Peer: "OBSERVER", Channel: ChannelFireAlerts, Received: 340, Late: 1},
+		},
+		Sent: 1628, BytesSent: 340900, DecodeErrors: 2,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	bid.Follow(status)
+	bid.ExpireIn(time.Second)
+	bid.ReplyTo = "_INBOX.T2.7"
+	vector := status
+	vector.Vector = make(clock.Vector)
+	for n := 1; n <= 5; n++ {
+		vector.Vector[fmt.Sprintf("T%d", n)] = uint64(500 + n)
+	}
+
+	samples := []codecSample{
+		{name: "status", msg: status},
+		{name: "bid", msg: bid},
+		{name: "stats", msg: stats},
+		{name: "status+vclock", msg: vector},
+	}
+	channels := []string{ChannelTruckStatus, ChannelFireBids, ChannelStatsReport, ChannelTruckStatus}
+	for i := range samples {
+		msg := &samples[i].msg
+		msg.Version = message.Version
+		msg.Stamp()
+		msg.To = channels[i]
+		msg.Seq = uint64(1000 + i)
+		msg.Epoch = time.Now().UnixNano()
+		msg.Lamport = int64(52000 + i)
+		if keyring != nil {
+			if err := keyring.Sign(msg); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return samples
+}
+
+func benchKeyring() *Keyring {
+	return NewKeyring(map[string]string{"*": "benchmark-secret"})
+}
+
+// BenchmarkMarshal reports the time, allocations and encoded size of each
+// codec on signed samples; compare with go test -bench=. -benchmem.
+func BenchmarkMarshal(b *testing.B) {
+	for _, s := range codecSamples(b, benchKeyring()) {
+		for _, codec := range Codecs {
+			b.Run(s.name+"/"+codec.Name(), func(b *testing.B) {
+				b.ReportAllocs()
+				var data []byte
+				for i := 0; i < b.N; i++ {
+					data, _ = codec.Marshal(s.msg)
+				}
+				b.ReportMetric(float64(len(data)), "bytes/msg")
+			})
+		}
+	}
+}
+
+// BenchmarkUnmarshal reports the time and allocations to decode each
+// codec's encoding of the signed samples.
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, s := range codecSamples(b, benchKeyring()) {
+		for _, codec := range Codecs {
+			data, err := codec.Marshal(s.msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(s.name+"/"+codec.Name(), func(b *testing.B) {
+				b.ReportAllocs()
+				var msg message.Message
+				for i := 0; i < b.N; i++ {
+					_ = codec.Unmarshal(data, &msg)
+				}
+				b.ReportMetric(float64(len(data)), "bytes/msg")
+			})
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, s := range codecSamples(t, nil) {
+		want, err := message.Canonical(s.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, codec := range Codecs {
+			data, err := codec.Marshal(s.msg)
+			if err != nil {
+				t.Fatalf("%s: Marshal %s: %v", codec.Name(), s.name, err)
+			}
+			if c, err := codecOf(data); err != nil || c.Name() != codec.Name() {
+				t.Errorf("%s: %s detected as %v (%v)", codec.Name(), s.name, c, err)
+			}
+
+			var got message.Message
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s: Unmarshal %s: %v", codec.Name(), s.name, err)
+			}
+			canonical, err := message.Canonical(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(canonical, want) {
+				t.Errorf("%s: %s decoded to\n%s\nwant\n%s", codec.Name(), s.name, canonical, want)
+			}
+			if got.ReplyTo != s.msg.ReplyTo {
+				t.Errorf("%s: %s reply channel %q, want %q", codec.Name(), s.name, got.ReplyTo, s.msg.ReplyTo)
+			}
+		}
+	}
+}
+
+func TestBinaryCodecSignatureSurvives(t *testing.T) {
+	keyring := benchKeyring()
+	for _, s := range codecSamples(t, keyring) {
+		data, err := BinaryCodec{}.Marshal(s.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.verifyData(data, decodeBinary(t, data)); err != nil {
+			t.Errorf("%s: %v", s.name, err)
+		}
+		if len(data) >= len(mustJSON(t, s.msg)) {
+			t.Errorf("%s: binary encoding is %d bytes, no smaller than JSON", s.name, len(data))
+		}
+	}
+}
+
+func TestBinaryCodecPayloadTypes(t *testing.T) {
+	msg := message.Message{Type: message.TypeTick, Payload: map[string]interface{}{
+		"int":   42,
+		"neg":   -7,
+		"whole": 3.0,
+		"frac":  2.5,
+		"big":   uint64(1) << 63,
+		"str":   "héllo",
+		"bool":  true,
+		"null":  nil,
+		"list":  []interface{}{1.0, "a", false},
+		"map":   map[string]interface{}{"x": 1.0},
+	}}
+	data, err := BinaryCodec{}.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeBinary(t, data).Payload
+
+	// Whole numbers stay integers
+	for key, want := range map[string]interface{}{
+		"int": int64(42), "neg": int64(-7), "whole": int64(3), "frac": 2.5,
+		"big": uint64(1) << 63, "str": "héllo", "bool": true, "null": nil,
+	} {
+		if got[key] != want {
+			t.Errorf("payload[%q] = %#v, want %#v", key, got[key], want)
+		}
+	}
+	if list, ok := got["list"].([]interface{}); !ok || len(list) != 3 || list[0] != int64(1) || list[1] != "a" {
+		t.Errorf("payload[list] = %#v", got["list"])
+	}
+	if m, ok := got["map"].(map[string]interface{}); !ok || m["x"] != int64(1) {
+		t.Errorf("payload[map] = %#v", got["map"])
+	}
+}
+
+func TestBinaryCodecTruncated(t *testing.T) {
+	data, err := BinaryCodec{}.Marshal(codecSamples(t, benchKeyring())[0].msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cutting into the last field must fail
+	var msg message.Message
+	if err := (BinaryCodec{}).Unmarshal(data[:len(data)-1], &msg); !errors.Is(err, errTruncated) {
+		t.Errorf("cut inside the last field: got %v, want %v", err, errTruncated)
+	}
+
+	// Any other cut either fails or decodes to fewer fields, but never panics
+	for n := 0; n < len(data); n++ {
+		_ = BinaryCodec{}.Unmarshal(data[:n], &msg)
+	}
+}
+
+func TestBinaryCodecCorrupted(t *testing.T) {
+	header := []byte{binaryMagic, binaryVersion}
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"field longer than message", append(header, fieldType, 0x7f, 'b', 'i', 'd')},
+		{"unterminated tag", append(header, 0x80)},
+		{"unknown value kind", append(header, fieldPayload, 1, 0xee)},
+		{"map count beyond message", append(header, fieldPayload, 2, kindMap, 0x7f)},
+		{"list count beyond message", append(header, fieldPayload, 2, kindList, 0x7f)},
+		{"short float", append(header, fieldPayload, 6, kindMap, 1, 1, 'x', kindFloat, 0)},
+		{"payload not a map", append(header, fieldPayload, 1, kindTrue)},
+		{"vector count beyond message", append(header, fieldVector, 1, 0x7f)},
+	} {
+		var msg message.Message
+		if err := (BinaryCodec{}).Unmarshal(tc.data, &msg); err == nil {
+			t.Errorf("%s: decoded %+v", tc.name, msg)
+		}
+	}
+
+	// Random damage must never panic
+	data, err := BinaryCodec{}.Marshal(codecSamples(t, benchKeyring())[2].msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		damaged := append([]byte(nil), data...)
+		for j := 0; j < 3; j++ {
+			damaged[2+rng.Intn(len(damaged)-2)] = byte(rng.Intn(256))
+		}
+		var msg message.Message
+		_ = BinaryCodec{}.Unmarshal(damaged, &msg)
+	}
+}
+
+func TestBinaryCodecWrongHeader(t *testing.T) {
+	data, err := BinaryCodec{}.Marshal(codecSamples(t, nil)[0].msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg message.Message
+	wrongMagic := append([]byte{0xB2}, data[1:]...)
+	if err := (BinaryCodec{}).Unmarshal(wrongMagic, &msg); err == nil {
+		t.Error("decoded a message with the wrong magic byte")
+	}
+	if c, _ := codecOf(wrongMagic); c.Name() != "json" {
+		t.Errorf("wrong magic byte detected as %s", c.Name())
+	}
+	newer := append([]byte{binaryMagic, binaryVersion + 1}, data[2:]...)
+	if err := (BinaryCodec{}).Unmarshal(newer, &msg); err == nil {
+		t.Error("decoded a message in an unknown binary format")
+	}
+	for _, short := range [][]byte{nil, {binaryMagic}} {
+		if err := (BinaryCodec{}).Unmarshal(short, &msg); err == nil {
+			t.Errorf("decoded %x", short)
+		}
+	}
+}
+
+func TestBinaryCodecSkipsUnknownFields(t *testing.T) {
+	data, err := BinaryCodec{}.Marshal(message.Message{Type: message.TypeTick, From: "T1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A field from a newer version, between the known ones
+	data = append(data[:2], append([]byte{0x3f, 2, 'h', 'i'}, data[2:]...)...)
+
+	msg := decodeBinary(t, data)
+	if msg.Type != message.TypeTick || msg.From != "T1" {
+		t.Errorf("got %s from %s, want %s from T1", msg.Type, msg.From, message.TypeTick)
+	}
+}
+
+func decodeBinary(t *testing.T, data []byte) message.Message {
+	t.Helper()
+	var msg message.Message
+	if err := (BinaryCodec{}).Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return msg
+}
+
+func mustJSON(t *testing.T, msg message.Message) []byte {
+	t.Helper()
+	data, err := JSONCodec{}.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseCodec(t *testing.T) {
+	for _, codec := range Codecs {
+		if c, err := ParseCodec(codec.Name()); err != nil || c.Name() != codec.Name() {
+			t.Errorf("ParseCodec(%q) = %v, %v", codec.Name(), c, err)
+		}
+	}
+	if _, err := ParseCodec("xml"); err == nil {
+		t.Error("ParseCodec(xml) succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// deadLetterMemory is how many rejected messages a node remembers so that
+// it reports each only once, however many of its subscriptions see it.
+const deadLetterMemory = 256
+
+// WithValidation checks every message delivered to a subscription against
+// the registry. Messages that fail never reach the handler; they are
+// reported on ChannelDeadLetter with the reason instead.
+func WithValidation(r *message.Registry) Option {
+	return func(e *endpoint) {
+		e.validator = r
+	}
+}
+
+// deadLetters remembers the rejected messages a node already reported.
+type deadLetters struct {
+	mu     sync.Mutex
+	seen   map[string]bool
+	recent []string // oldest first
+}
+
+// first tells whether id was not reported before and remembers it.
+func (d *deadLetters) first(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen[id] {
+		return false
+	}
+	if d.seen == nil {
+		d.seen = make(map[string]bool)
+	}
+	d.seen[id] = true
+	d.recent = append(d.recent, id)
+	if len(d.recent) > deadLetterMemory {
+		delete(d.seen, d.recent[0])
+		d.recent = d.recent[1:]
+	}
+	return true
+}
+
+// validated drops messages that fail validation and reports them on
+// ChannelDeadLetter through publish.
+func (e *endpoint) validated(handler SubscriptionHandler) SubscriptionHandler {
+	if e.validator == nil {
+		return handler
+	}
+	return func(msg message.Message) error {
+		err := e.validator.Validate(msg)
+		if err == nil {
+			return handler(msg)
+		}
+		// Unsequenced messages cannot be told apart and are always reported
+		if msg.Seq != 0 && !e.dead.first(orderID(msg)) {
+			return nil
+		}
+		e.traffic.rejected(msg.Channel)
+		fmt.Printf("[%s] rejected %s from %s on %s: %v\n", e.id, msg.Type, msg.From, msg.Channel, err)
+
+		if msg.Channel == ChannelDeadLetter || e.publish == nil {
+			// Never report a report
+			return nil
+		}
+		letter, lerr := message.New(message.TypeDeadLetter, e.id, message.DeadLetter{
+			Channel: msg.Channel,
+			Reason:  err.Error(),
+			Message: msg,
+		})
+		if lerr == nil {
+			lerr = e.publish(ChannelDeadLetter, letter)
+		}
+		if lerr != nil {
+			return fmt.Errorf("failed to report rejected message: %w", lerr)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,307 @@
+package transport
+
+import (
+	"Firetruck-sim/pkg/clock"
+	"Firetruck-sim/pkg/message"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Option configures optional features of a transport.
+type Option func(*endpoint)
+
+// WithKeyring signs every outgoing message and drops incoming messages
+// whose signature does not verify against the keyring.
+func WithKeyring(k *Keyring) Option {
+	return func(e *endpoint) {
+		e.keyring = k
+	}
+}
+
+// WithNamespace scopes every channel to a run so that several simulations
+// can share one broker. Channels become "run.<run>.<channel>", except the
+// global ChannelRuns.
+func WithNamespace(run string) Option {
+	return func(e *endpoint) {
+		e.namespace = run
+	}
+}
+
+// WithVectorClock stamps every outgoing message with the node's vector
+// timestamp and merges the timestamps of incoming messages, so receivers
+// can tell concurrent messages from causally ordered ones.
+func WithVectorClock() Option {
+	return func(e *endpoint) {
+		e.vclock = clock.NewVectorClock(e.id)
+	}
+}
+
+// WithTTL makes messages published on channels matching pattern expire
+// ttl after they are sent, unless the sender set an expiry itself.
+// Receivers drop expired messages before any handler sees them.
+func WithTTL(pattern string, ttl time.Duration) Option {
+	return func(e *endpoint) {
+		if e.ttls == nil {
+			e.ttls = make(map[string]time.Duration)
+		}
+		e.ttls[pattern] = ttl
+	}
+}
+
+// endpoint holds the state every Transport implementation shares: the node
+// identity, its Lamport clock, the simulated partition and the wire encoding
+// of messages.
+type endpoint struct {
+	id         string
+	clock      clock.Clock
+	vclock     *clock.VectorClock
+	partitions *PartitionTable
+	keyring    *Keyring
+	namespace  string
+	codec      Codec
+	ttls       map[string]time.Duration // by channel pattern
+
+	authFailures atomic.Uint64
+
+	epoch  int64
+	seqMu  sync.Mutex
+	seqOut map[string]uint64
+	seqIn  *SeqTracker
+
+	life    lifecycle
+	traffic traffic
+
+	queueCfg    *QueueConfig
+	sharedQueue *deliveryQueue
+	queueMu     sync.Mutex
+	queues      []*deliveryQueue
+
+	validator *message.Registry
+	dead      deadLetters
+	// publish is the transport's Publish, for dead letters
+	publish func(channel string, msg message.Message) error
+}
+
+func newEndpoint(id string, opts []Option) *endpoint {
+	e := &endpoint{
+		id:         id,
+		clock:      clock.NewLamportClock(),
+		codec:      JSONCodec{},
+		partitions: NewPartitionTable(),
+		epoch:      time.Now().UnixNano(),
+		seqOut:     make(map[string]uint64),
+		seqIn:      NewSeqTracker(),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// subject maps a channel to the broker subject for this node's run.
+func (e *endpoint) subject(channel string) string {
+	if e.namespace == "" || channel == ChannelRuns {
+		return channel
+	}
+	return "run." + e.namespace + "." + channel
+}
+
+// channelOf maps a broker subject back to the channel it carries.
+func (e *endpoint) channelOf(subject string) string {
+	if e.namespace == "" {
+		return subject
+	}
+	return strings.TrimPrefix(subject, "run."+e.namespace+".")
+}
+
+// GetID returns the transport's unique identifier.
+func (e *endpoint) GetID() string {
+	return e.id
+}
+
+// SetClock sets the clock, Lamport or hybrid, that stamps this node's messages
+func (e *endpoint) SetClock(clock clock.Clock) {
+	e.clock = clock
+}
+
+// encode stamps the sender, envelope, timestamp and, unless channel is
+// empty, the destination and next sequence number for channel on msg and
+// marshals it for the wire. Replies pass an empty channel and carry the
+// reply channel as their destination.
+func (e *endpoint) encode(channel string, msg message.Message) ([]byte, error) {
+	msg.From = e.id
+	if msg.Version == 0 {
+		msg.Version = message.Version
+	}
+	msg.Stamp()
+	if ttl := e.ttl(channel); msg.Expires == 0 && ttl > 0 {
+		msg.ExpireIn(ttl)
+	}
+	if channel != "" {
+		msg.To = channel
+		msg.Seq = e.nextSeq(channel)
+		msg.Epoch = e.epoch
+	}
+	// Only set Lamport if not already set by caller
+	if msg.Lamport == 0 {
+		msg.Lamport = e.clock.Tick()
+	}
+	if e.vclock != nil {
+		msg.Vector = e.vclock.Tick()
+	}
+	if e.keyring != nil {
+		if err := e.keyring.Sign(&msg); err != nil {
+			return nil, err
+		}
+	}
+	return e.encodeRaw(channel, msg)
+}
+
+// encodeReplay marshals a recorded message for Republish. Replay moves the
+// expiry of messages that have one, so with a keyring those are signed
+// again; this needs the key of the original sender.
+func (e *endpoint) encodeReplay(channel string, msg message.Message) ([]byte, error) {
+	if e.keyring != nil && msg.Expires != 0 {
+		if err := e.keyring.Sign(&msg); err != nil {
+			return nil, err
+		}
+	}
+	return e.encodeRaw(channel, msg)
+}
+
+// encodeRaw marshals msg for the wire with the transport's codec, without
+// stamping it.
+func (e *endpoint) encodeRaw(channel string, msg message.Message) ([]byte, error) {
+	data, err := e.codec.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal broadcast message: %w", err)
+	}
+	e.traffic.sent(channel, len(data))
+	return data, nil
+}
+
+// ttl returns the time to live of messages on channel, or zero.
+func (e *endpoint) ttl(channel string) time.Duration {
+	for pattern, ttl := range e.ttls {
+		if MatchChannel(pattern, channel) {
+			return ttl
+		}
+	}
+	return 0
+}
+
+// AuthFailures returns how many incoming messages were dropped because
+// they failed authentication.
+func (e *endpoint) AuthFailures() uint64 {
+	return e.authFailures.Load()
+}
+
+// decode unmarshals a received message with the codec that encoded it,
+// authenticates it and upgrades it to the current message version. A
+// non-empty replyTo, as carried out of band by NATS, overrides the
+// message's own.
+func (e *endpoint) decode(replyTo string, data []byte) (message.Message, error) {
+	var msg message.Message
+	codec, err := codecOf(data)
+	if err != nil {
+		return msg, err
+	}
+	if err := codec.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	if e.keyring != nil {
+		if err := e.keyring.verifyData(data, msg); err != nil {
+			e.authFailures.Add(1)
+			return msg, err
+		}
+	}
+	msg, err = message.Upgrade(msg)
+	if err != nil {
+		return msg, err
+	}
+	if replyTo != "" {
+		msg.ReplyTo = replyTo
+	}
+	return msg, nil
+}
+
+// deliver decodes a message received on channel and runs the handler.
+// Signed messages must have been sent to channel. Messages from nodes on
+// the other side of a partition are dropped as if they never arrived.
+func (e *endpoint) deliver(channel, replyTo string, data []byte, handler SubscriptionHandler) {
+	e.traffic.received(channel, len(data))
+	msg, err := e.decode(replyTo, data)
+	if err == nil && e.keyring != nil && msg.To != channel {
+		e.authFailures.Add(1)
+		err = fmt.Errorf("%w: %s sent it to %q", ErrUnauthenticated, msg.From, msg.To)
+	}
+	if errors.Is(err, ErrUnauthenticated) {
+		fmt.Printf("[%s] dropped message on %s: %v (%d rejected)\n", e.id, channel, err, e.AuthFailures())
+		return
+	}
+	if err != nil {
+		e.traffic.decodeFailed(channel)
+		fmt.Printf("Error unmarshaling broadcast message: %v\n", err)
+		return
+	}
+
+	msg.Channel = channel
+
+	if channel != ChannelControlPartition && !e.partitions.Reachable(msg.From, e.id) {
+		return
+	}
+
+	e.receiveClocks(msg)
+
+	// Call handler
+	if err := handler(msg); err != nil {
+		fmt.Printf("Error handling broadcast message: %v\n", err)
+	}
+}
+
+// receiveClocks updates the node's clocks from a received message.
+func (e *endpoint) receiveClocks(msg message.Message) {
+	e.clock.Receive(msg.Lamport)
+	if e.vclock != nil && msg.Vector != nil {
+		e.vclock.Merge(msg.Vector)
+	}
+}
+
+// prepare wraps a subscription handler so that replays and expired
+// messages are dropped, with WithValidation invalid messages are rejected,
+// the handler is timed and, with WithDeliveryQueue, messages are handled
+// from a bounded queue.
+func (e *endpoint) prepare(channel string, handler SubscriptionHandler) SubscriptionHandler {
+	return e.seqIn.Filter(channel, e.fresh(e.validated(e.queued(channel, e.instrument(handler)))))
+}
+
+// fresh drops messages that expired before they arrived.
+func (e *endpoint) fresh(handler SubscriptionHandler) SubscriptionHandler {
+	return func(msg message.Message) error {
+		if msg.Expired(time.Now()) {
+			e.traffic.expired(msg.Channel)
+			return nil
+		}
+		return handler(msg)
+	}
+}
+
+// handlePartition installs the partition carried by a control message.
+func (e *endpoint) handlePartition(msg message.Message) error {
+	p, err := message.Decode[message.Partition](msg)
+	if err != nil {
+		return err
+	}
+	groups, err := ParsePartition(p.Spec)
+	if err != nil {
+		return fmt.Errorf("invalid partition from %s: %w", msg.From, err)
+	}
+
+	e.partitions.Set(groups)
+	fmt.Printf("[%s] partition now %s\n", e.id, FormatPartition(groups))
+	return nil
+}
@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// reorderWindow is how long a held-back message waits for a successor
+// before it is delivered anyway.
+const reorderWindow = 500 * time.Millisecond
+
+// FaultConfig describes the faults injected into one channel.
+type FaultConfig struct {
+	Drop      float64       // probability a message is dropped
+	Duplicate float64       // probability a message is delivered twice
+	Reorder   float64       // probability a message is held back behind the next one
+	Delay     time.Duration // maximum extra delivery delay, chosen uniformly
+}
+
+// FaultStats counts the faults injected so far.
+type FaultStats struct {
+	Dropped    uint64
+	Duplicated uint64
+	Reordered  uint64
+	Delayed    uint64
+	Expired    uint64 // delayed past their TTL, and dropped
+}
+
+// FaultyTransport wraps a Transport and injects faults into the messages
+// delivered to its subscribers. Faults are configured per channel; the key
+// "*" applies to every channel without its own entry. Publishing is not
+// affected, so each node only disturbs its own view of the network.
+type FaultyTransport struct {
+	Transport
+
+	faults map[string]FaultConfig
+	seq    *SeqTracker // replays and losses as seen after fault injection
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	dropped, duplicated, reordered, delayed, expired atomic.Uint64
+}
+
+// NewFaultyTransport wraps inner with a fault injector driven by a seeded RNG.
+func NewFaultyTransport(inner Transport, seed int64, faults map[string]FaultConfig) *FaultyTransport {
+	return &FaultyTransport{
+		Transport: inner,
+		faults:    faults,
+		seq:       NewSeqTracker(),
+		rng:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Subscribe registers handler on the inner transport behind the fault injector.
+func (ft *FaultyTransport) Subscribe(channel string, handler SubscriptionHandler) error {
+	return ft.Transport.Subscribe(channel, ft.wrap(channel, handler))
+}
+
+// SubscribeInbox registers an inbox handler behind the fault injector.
+// Inbox faults are configured under the node's inbox channel name.
+func (ft *FaultyTransport) SubscribeInbox(handler SubscriptionHandler) error {
+	return ft.Transport.SubscribeInbox(ft.wrap(InboxChannel(ft.GetID()), handler))
+}
+
+// wrap puts handler behind the faults configured for channel, if any.
+func (ft *FaultyTransport) wrap(channel string, handler SubscriptionHandler) SubscriptionHandler {
+	cfg, ok := ft.faults[channel]
+	if !ok {
+		cfg, ok = ft.faults["*"]
+	}
+	if !ok {
+		return handler
+	}
+
+	// Injected duplicates are counted but still reach the handler; the
+	// inner transport already dropped the network's own
+	s := &faultySub{ft: ft, cfg: cfg, handler: ft.seq.Watch(channel, handler)}
+	return s.receive
+}
+
+// SeqStats reports duplicates and gaps as the handlers see them, i.e.
+// including the faults injected on channels that have any.
+func (ft *FaultyTransport) SeqStats() []SeqStats {
+	type key struct{ peer, channel string }
+	merged := make(map[key]SeqStats)
+	for _, s := range ft.Transport.SeqStats() {
+		merged[key{s.Peer, s.Channel}] = s
+	}
+	for _, s := range ft.seq.Stats() {
+		merged[key{s.Peer, s.Channel}] = s
+	}
+
+	stats := make([]SeqStats, 0, len(merged))
+	for _, s := range merged {
+		stats = append(stats, s)
+	}
+	sortSeqStats(stats)
+	return stats
+}
+
+// FaultStats returns the number of faults injected so far.
+func (ft *FaultyTransport) FaultStats() FaultStats {
+	return FaultStats{
+		Dropped:    ft.dropped.Load(),
+		Duplicated: ft.duplicated.Load(),
+		Reordered:  ft.reordered.Load(),
+		Delayed:    ft.delayed.Load(),
+		Expired:    ft.expired.Load(),
+	}
+}
+
+// roll draws the fault decisions for one message.
+func (ft *FaultyTransport) roll(cfg FaultConfig) (drop, dup, reorder bool, delay time.Duration) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	drop = ft.rng.Float64() < cfg.Drop
+	dup = ft.rng.Float64() < cfg.Duplicate
+	reorder = ft.rng.Float64() < cfg.Reorder
+	if cfg.Delay > 0 {
+		delay = time.Duration(ft.rng.Int63n(int64(cfg.Delay) + 1))
+	}
+	return drop, dup, reorder, delay
+}
+
+// faultySub holds the reorder state of a single subscription.
+type faultySub struct {
+	ft      *FaultyTransport
+	cfg     FaultConfig
+	handler SubscriptionHandler
+
+	mu    sync.Mutex
+	held  *message.Message
+	timer *time.Timer
+}
+
+func (s *faultySub) receive(msg message.Message) error {
+	drop, dup, reorder, delay := s.ft.roll(s.cfg)
+	if drop {
+		s.ft.dropped.Add(1)
+		return nil
+	}
+
+	s.mu.Lock()
+	if reorder && s.held == nil {
+		// Hold this message until the next one has been delivered
+		s.ft.reordered.Add(1)
+		s.held = &msg
+		s.timer = time.AfterFunc(reorderWindow, s.flush)
+		s.mu.Unlock()
+		return nil
+	}
+	held := s.held
+	s.held = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+
+	err := s.emit(msg, delay)
+	if dup {
+		s.ft.duplicated.Add(1)
+		_ = s.emit(msg, delay)
+	}
+	if held != nil {
+		_ = s.emit(*held, 0)
+	}
+	return err
+}
+
+// flush delivers a held message that never got a successor.
+func (s *faultySub) flush() {
+	s.mu.Lock()
+	held := s.held
+	s.held = nil
+	s.timer = nil
+	s.mu.Unlock()
+
+	if held != nil {
+		_ = s.emit(*held, 0)
+	}
+}
+
+// emit runs the handler now, or later on a timer when delay is non-zero.
+// Messages delayed past their TTL are dropped, as a real network delay
+// would have the receiving transport do.
+func (s *faultySub) emit(msg message.Message, delay time.Duration) error {
+	if delay <= 0 {
+		return s.handler(msg)
+	}
+	s.ft.delayed.Add(1)
+	time.AfterFunc(delay, func() {
+		if msg.Expired(time.Now()) {
+			s.ft.expired.Add(1)
+			return
+		}
+		if err := s.handler(msg); err != nil {
+			fmt.Printf("Error handling broadcast message: %v\n", err)
+		}
+	})
+	return nil
+}
+
+// ParseFaultSpec parses a fault specification such as
+//
+//	water.req:drop=0.2,delay=300ms;node.T1.inbox:dup=0.1,reorder=0.5
+//
+// into per-channel configs. Use "*" as the channel to match every channel.
+func ParseFaultSpec(spec string) (map[string]FaultConfig, error) {
+	faults := make(map[string]FaultConfig)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		channel, opts, ok := strings.Cut(entry, ":")
+		if !ok || channel == "" {
+			return nil, fmt.Errorf("invalid fault entry %q: want channel:key=value,...", entry)
+		}
+
+		var cfg FaultConfig
+		for _, opt := range strings.Split(opts, ",") {
+			key, val, ok := strings.Cut(strings.TrimSpace(opt), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid fault option %q for %s", opt, channel)
+			}
+
+			var err error
+			switch key {
+			case "drop":
+				cfg.Drop, err = parseProbability(val)
+			case "dup":
+				cfg.Duplicate, err = parseProbability(val)
+			case "reorder":
+				cfg.Reorder, err = parseProbability(val)
+			case "delay":
+				cfg.Delay, err = time.ParseDuration(val)
+			default:
+				err = fmt.Errorf("unknown fault %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault option %q for %s: %w", opt, channel, err)
+			}
+		}
+		faults[channel] = cfg
+	}
+	return faults, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability %v out of range [0,1]", p)
+	}
+	return p, nil
+}
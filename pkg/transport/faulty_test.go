@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"testing"
+
+	"Firetruck-sim/pkg/message"
+)
+
+func TestFaultyDuplicatesReachHandlers(t *testing.T) {
+	bus := NewMemBus()
+	sender := NewMemTransport("T1", bus)
+	defer sender.Close()
+	ft := NewFaultyTransport(NewMemTransport("T2", bus), 1, map[string]FaultConfig{
+		ChannelFireAlerts: {Duplicate: 1},
+	})
+	defer ft.Close()
+
+	got := collect(t, ft, ChannelFireAlerts)
+	const sent = 5
+	for i := 0; i < sent; i++ {
+		if err := sender.Publish(ChannelFireAlerts, message.Message{Type: message.TypeFireAlert}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2*sent; i++ {
+		receive(t, got)
+	}
+	expectNone(t, got)
+
+	if n := ft.FaultStats().Duplicated; n != sent {
+		t.Errorf("Duplicated = %d, want %d", n, sent)
+	}
+	stats := ft.SeqStats()
+	if len(stats) != 1 || stats[0].Duplicates != sent || stats[0].Received != sent || stats[0].Gaps != 0 {
+		t.Errorf("SeqStats = %+v, want %d received and %d duplicates from T1", stats, sent, sent)
+	}
+}
+
+func TestParseFaultSpec(t *testing.T) {
+	faults, err := ParseFaultSpec("water.req:drop=0.2,delay=300ms; *:dup=0.1,reorder=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := faults[ChannelWaterReq]; cfg.Drop != 0.2 || cfg.Delay.Milliseconds() != 300 {
+		t.Errorf("water.req = %+v", cfg)
+	}
+	if cfg := faults["*"]; cfg.Duplicate != 0.1 || cfg.Reorder != 0.5 {
+		t.Errorf("* = %+v", cfg)
+	}
+
+	for _, spec := range []string{"water.req", "water.req:drop=2", "water.req:lose=0.1", ":dup=0.1"} {
+		if _, err := ParseFaultSpec(spec); err == nil {
+			t.Errorf("ParseFaultSpec(%q) succeeded", spec)
+		}
+	}
+}
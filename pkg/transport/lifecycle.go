@@ -0,0 +1,86 @@
+package transport
+
+import "sync"
+
+// lifecycle holds the callbacks registered for connection changes.
+// Callbacks run on a separate goroutine from the transport's own.
+type lifecycle struct {
+	mu           sync.Mutex
+	disconnected []func(error)
+	reconnected  []func()
+	closed       []func()
+	down         bool // lost the connection and not yet back
+}
+
+// OnDisconnect registers fn to run when the transport loses its connection.
+func (e *endpoint) OnDisconnect(fn func(err error)) {
+	e.life.mu.Lock()
+	e.life.disconnected = append(e.life.disconnected, fn)
+	e.life.mu.Unlock()
+}
+
+// OnReconnect registers fn to run when the transport is connected again
+// after a disconnect. Subscriptions are already restored when it runs.
+func (e *endpoint) OnReconnect(fn func()) {
+	e.life.mu.Lock()
+	e.life.reconnected = append(e.life.reconnected, fn)
+	e.life.mu.Unlock()
+}
+
+// OnClose registers fn to run once the transport is closed for good.
+func (e *endpoint) OnClose(fn func()) {
+	e.life.mu.Lock()
+	e.life.closed = append(e.life.closed, fn)
+	e.life.mu.Unlock()
+}
+
+// notifyDisconnect runs the disconnect callbacks, once per outage.
+func (e *endpoint) notifyDisconnect(err error) {
+	e.life.mu.Lock()
+	if e.life.down {
+		e.life.mu.Unlock()
+		return
+	}
+	e.life.down = true
+	fns := e.life.disconnected
+	e.life.mu.Unlock()
+
+	go func() {
+		for _, fn := range fns {
+			fn(err)
+		}
+	}()
+}
+
+// notifyReconnect runs the reconnect callbacks if the transport was down.
+func (e *endpoint) notifyReconnect() {
+	e.life.mu.Lock()
+	if !e.life.down {
+		e.life.mu.Unlock()
+		return
+	}
+	e.life.down = false
+	fns := e.life.reconnected
+	e.life.mu.Unlock()
+
+	go runAll(fns)
+}
+
+// notifyClosed runs the close callbacks.
+func (e *endpoint) notifyClosed() {
+	e.life.mu.Lock()
+	fns := e.life.closed
+	e.life.closed = nil
+	e.life.mu.Unlock()
+
+	go runAll(fns)
+}
+
+// runAll runs callbacks in order. Callers start it on its own goroutine so
+// a slow callback, e.g. one that makes a request, cannot stall the
+// connection that triggered it.
+func runAll(fns []func()) {
+	for _, fn := range fns {
+		fn()
+	}
+}
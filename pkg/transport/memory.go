@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// MemBus is an in-process message bus. Every MemTransport connected to the
+// same bus sees the others' broadcasts, so a whole simulation can run in one
+// process without a NATS server.
+type MemBus struct {
+	mu   sync.RWMutex
+	subs map[string][]*asyncSub
+}
+
+// NewMemBus creates an empty in-process bus.
+func NewMemBus() *MemBus {
+	return &MemBus{subs: make(map[string][]*asyncSub)}
+}
+
+// publish hands data to every subscription whose subject matches the
+// channel and returns how many subscriptions received it.
+func (b *MemBus) publish(channel string, data []byte) int {
+	var subs []*asyncSub
+	b.mu.RLock()
+	for subject, list := range b.subs {
+		if subject == channel || MatchChannel(subject, channel) {
+			subs = append(subs, list...)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		s.push(channel, data)
+	}
+	return len(subs)
+}
+
+func (b *MemBus) add(s *asyncSub) {
+	b.mu.Lock()
+	b.subs[s.subject] = append(b.subs[s.subject], s)
+	b.mu.Unlock()
+}
+
+func (b *MemBus) remove(s *asyncSub) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.subs[s.subject]
+	for i, other := range subs {
+		if other == s {
+			// Copy so concurrent publishers keep a consistent slice
+			next := make([]*asyncSub, 0, len(subs)-1)
+			next = append(next, subs[:i]...)
+			b.subs[s.subject] = append(next, subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// MemTransport implements the Transport interface on top of a MemBus.
+type MemTransport struct {
+	*endpoint
+	bus *MemBus
+
+	mu     sync.Mutex
+	subs   []*asyncSub
+	closed bool
+
+	requests atomic.Uint64 // numbers reply channels
+}
+
+// NewMemTransport connects a new node to the given bus.
+func NewMemTransport(id string, bus *MemBus, opts ...Option) *MemTransport {
+	mt := &MemTransport{
+		endpoint: newEndpoint(id, opts),
+		bus:      bus,
+	}
+	mt.publish = mt.Publish
+
+	// Always listen for partition changes so they can be applied mid-run
+	_ = mt.Subscribe(ChannelControlPartition, mt.handlePartition)
+	return mt
+}
+
+// Publish broadcasts a message to all subscribers of a channel.
+// Messages are encoded exactly as on the wire, so subscribers see the same
+// types (numbers as float64) as they would over NATS.
+func (mt *MemTransport) Publish(channel string, msg message.Message) error {
+	return mt.publishSubject(channel, mt.subject(channel), msg)
+}
+
+// publishSubject hands msg to the subscribers of subject. Replies pass an
+// empty channel and are not sequenced.
+func (mt *MemTransport) publishSubject(channel, subject string, msg message.Message) error {
+	if mt.isClosed() {
+		return ErrClosed
+	}
+
+	data, err := mt.encode(channel, msg)
+	if err != nil {
+		return err
+	}
+
+	mt.bus.publish(subject, data)
+	return nil
+}
+
+// Republish publishes msg as recorded elsewhere, keeping its sender,
+// timestamp, sequence number and, unless it expires, signature.
+func (mt *MemTransport) Republish(channel string, msg message.Message) error {
+	if mt.isClosed() {
+		return ErrClosed
+	}
+
+	data, err := mt.encodeReplay(channel, msg)
+	if err != nil {
+		return err
+	}
+
+	mt.bus.publish(mt.subject(channel), data)
+	return nil
+}
+
+// Subscribe starts listening to broadcast messages on a channel.
+func (mt *MemTransport) Subscribe(channel string, handler SubscriptionHandler) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if mt.closed {
+		return ErrClosed
+	}
+
+	handler = mt.prepare(channel, handler)
+	s := newAsyncSub(mt.subject(channel), func(subject string, data []byte) {
+		mt.deliver(mt.channelOf(subject), "", data, handler)
+	})
+	mt.subs = append(mt.subs, s)
+	mt.bus.add(s)
+	return nil
+}
+
+// Send delivers a message to a single node via its inbox channel.
+func (mt *MemTransport) Send(to string, msg message.Message) error {
+	return mt.Publish(InboxChannel(to), msg)
+}
+
+// SubscribeInbox starts listening to messages sent to this node.
+func (mt *MemTransport) SubscribeInbox(handler SubscriptionHandler) error {
+	return mt.Subscribe(InboxChannel(mt.id), handler)
+}
+
+// Request publishes a message and waits for the first reply on a private
+// reply channel, mirroring NATS request/reply.
+func (mt *MemTransport) Request(channel string, msg message.Message, timeout time.Duration) (message.Message, error) {
+	if mt.isClosed() {
+		return message.Message{}, ErrClosed
+	}
+
+	replyTo := fmt.Sprintf("_INBOX.%s.%d", mt.id, mt.requests.Add(1))
+	replies := make(chan message.Message, 1)
+	s := newAsyncSub(replyTo, func(_ string, data []byte) {
+		mt.deliver(replyTo, "", data, func(reply message.Message) error {
+			select {
+			case replies <- reply:
+			default: // only the first reply counts
+			}
+			return nil
+		})
+	})
+	mt.bus.add(s)
+	defer func() {
+		mt.bus.remove(s)
+		s.stop()
+	}()
+
+	msg.ReplyTo = replyTo
+	data, err := mt.encode(channel, msg)
+	if err != nil {
+		return message.Message{}, err
+	}
+	if mt.bus.publish(mt.subject(channel), data) == 0 {
+		return message.Message{}, fmt.Errorf("request on %s: %w", channel, ErrNoResponders)
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil // clock already updated on delivery
+	case <-time.After(timeout):
+		return message.Message{}, fmt.Errorf("request on %s: %w", channel, ErrTimeout)
+	}
+}
+
+// Reply answers a message received through Request.
+func (mt *MemTransport) Reply(req message.Message, resp message.Message) error {
+	if req.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+	if resp.Parent == "" {
+		resp.Follow(req)
+	}
+	resp.To = req.ReplyTo
+
+	// Reply channels are unique and never namespaced
+	return mt.publishSubject("", req.ReplyTo, resp)
+}
+
+// Close detaches the transport from the bus and stops its subscriptions.
+func (mt *MemTransport) Close() error {
+	mt.mu.Lock()
+	subs := mt.subs
+	mt.subs = nil
+	mt.closed = true
+	mt.mu.Unlock()
+
+	for _, s := range subs {
+		mt.bus.remove(s)
+		s.stop()
+	}
+	mt.stopQueues()
+	mt.notifyClosed()
+	return nil
+}
+
+func (mt *MemTransport) isClosed() bool {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return mt.closed
+}
@@ -0,0 +1,627 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+const (
+	meshDialInterval = time.Second
+	meshDialTimeout  = time.Second
+	meshIOTimeout    = 5 * time.Second
+)
+
+// Frame kinds exchanged between mesh peers
+const (
+	frameHello = "hello" // first frame on a connection: who we are
+	framePeers = "peers" // addresses of the peers we are connected to
+	frameSub   = "sub"   // we now have subscribers on a subject
+	frameUnsub = "unsub" // we no longer have subscribers on a subject
+	frameMsg   = "msg"   // an encoded message for a subject
+)
+
+// MeshConfig configures a MeshTransport.
+type MeshConfig struct {
+	// Listen is the address to accept peers on, e.g. "127.0.0.1:7001"
+	Listen string
+	// Peers are addresses to dial: a full static peer list, or just a few
+	// seed nodes from which the rest of the mesh is learned
+	Peers []string
+}
+
+// meshFrame is the unit written to a peer connection, one JSON value each.
+type meshFrame struct {
+	Kind    string            `json:"kind"`
+	ID      string            `json:"id,omitempty"`
+	Addr    string            `json:"addr,omitempty"`
+	Peers   map[string]string `json:"peers,omitempty"`
+	Subject string            `json:"subject,omitempty"`
+	Data    json.RawMessage   `json:"data,omitempty"`
+	Bin     []byte            `json:"bin,omitempty"` // a message not in JSON
+}
+
+// msgFrame wraps an encoded message for a peer. JSON messages are carried
+// as they are; others, such as BinaryCodec's, in base64.
+func msgFrame(subject string, data []byte) meshFrame {
+	if isJSON(data) {
+		return meshFrame{Kind: frameMsg, Subject: subject, Data: data}
+	}
+	return meshFrame{Kind: frameMsg, Subject: subject, Bin: data}
+}
+
+// message returns the encoded message a frameMsg carries.
+func (f meshFrame) message() []byte {
+	if len(f.Bin) > 0 {
+		return f.Bin
+	}
+	return f.Data
+}
+
+// meshPeer is an established connection to another node.
+type meshPeer struct {
+	id        string
+	addr      string // address the peer accepts connections on
+	initiator string // node that dialed this connection
+	conn      net.Conn
+
+	wmu sync.Mutex
+	enc *json.Encoder
+}
+
+func (p *meshPeer) send(f meshFrame) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	_ = p.conn.SetWriteDeadline(time.Now().Add(meshIOTimeout))
+	return p.enc.Encode(f)
+}
+
+// MeshTransport implements the Transport interface over direct TCP
+// connections between nodes, with no broker. Nodes gossip the addresses of
+// their peers until every node is connected to every other, and tell each
+// peer which subjects they subscribe to so messages are only sent to nodes
+// that want them.
+type MeshTransport struct {
+	*endpoint
+	ln   net.Listener
+	addr string
+
+	mu       sync.Mutex
+	peers    map[string]*meshPeer       // connected peers by node ID
+	interest map[string]map[string]bool // subjects each peer subscribes to
+	dial     map[string]string          // addresses to keep connected, to node ID once known
+	subs     map[string][]*asyncSub     // local subscriptions by subject
+	closed   bool
+	done     chan struct{}
+
+	requests atomic.Uint64 // numbers reply channels
+}
+
+// NewMeshTransport starts listening for peers and dials the configured ones.
+func NewMeshTransport(id string, cfg MeshConfig, opts ...Option) (*MeshTransport, error) {
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Listen, err)
+	}
+
+	mt := &MeshTransport{
+		endpoint: newEndpoint(id, opts),
+		ln:       ln,
+		addr:     ln.Addr().String(),
+		peers:    make(map[string]*meshPeer),
+		interest: make(map[string]map[string]bool),
+		dial:     make(map[string]string),
+		subs:     make(map[string][]*asyncSub),
+		done:     make(chan struct{}),
+	}
+	mt.publish = mt.Publish
+	for _, addr := range cfg.Peers {
+		mt.dial[addr] = ""
+	}
+
+	// Always listen for partition changes so they can be applied mid-run
+	_ = mt.Subscribe(ChannelControlPartition, mt.handlePartition)
+
+	go mt.acceptLoop()
+	go mt.dialLoop()
+	return mt, nil
+}
+
+// Addr returns the address this node accepts peer connections on.
+func (mt *MeshTransport) Addr() string {
+	return mt.addr
+}
+
+// Peers returns the IDs of the currently connected peers.
+func (mt *MeshTransport) Peers() []string {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	ids := make([]string, 0, len(mt.peers))
+	for id := range mt.peers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Publish broadcasts a message to all subscribers of a channel, local or remote.
+func (mt *MeshTransport) Publish(channel string, msg message.Message) error {
+	_, err := mt.publishSubject(channel, mt.subject(channel), msg)
+	return err
+}
+
+// publishSubject routes msg to every subscriber of subject and returns how
+// many nodes it was handed to. Replies pass an empty channel and are not
+// sequenced.
+func (mt *MeshTransport) publishSubject(channel, subject string, msg message.Message) (int, error) {
+	data, err := mt.encode(channel, msg)
+	if err != nil {
+		return 0, err
+	}
+	return mt.route(subject, data)
+}
+
+// Republish publishes msg as recorded elsewhere, keeping its sender,
+// timestamp, sequence number and, unless it expires, signature.
+func (mt *MeshTransport) Republish(channel string, msg message.Message) error {
+	data, err := mt.encodeReplay(channel, msg)
+	if err != nil {
+		return err
+	}
+	_, err = mt.route(mt.subject(channel), data)
+	return err
+}
+
+// route hands encoded data to every subscriber of subject and returns how
+// many nodes it was handed to.
+func (mt *MeshTransport) route(subject string, data []byte) (int, error) {
+	mt.mu.Lock()
+	if mt.closed {
+		mt.mu.Unlock()
+		return 0, ErrClosed
+	}
+	local := mt.localSubsLocked(subject)
+	var remote []*meshPeer
+	for id, p := range mt.peers {
+		if interested(mt.interest[id], subject) {
+			remote = append(remote, p)
+		}
+	}
+	mt.mu.Unlock()
+
+	n := len(remote)
+	if len(local) > 0 {
+		n++
+	}
+	for _, s := range local {
+		s.push(subject, data)
+	}
+	for _, p := range remote {
+		if err := p.send(msgFrame(subject, data)); err != nil {
+			// The read loop notices the closed connection and drops the peer
+			_ = p.conn.Close()
+		}
+	}
+	return n, nil
+}
+
+// Subscribe starts listening to broadcast messages on a channel.
+func (mt *MeshTransport) Subscribe(channel string, handler SubscriptionHandler) error {
+	handler = mt.prepare(channel, handler)
+	_, err := mt.subscribeSubject(mt.subject(channel), func(subject string, data []byte) {
+		mt.deliver(mt.channelOf(subject), "", data, handler)
+	})
+	return err
+}
+
+// localSubsLocked returns the subscriptions of this node matching subject.
+// Callers hold mt.mu.
+func (mt *MeshTransport) localSubsLocked(subject string) []*asyncSub {
+	var subs []*asyncSub
+	for pattern, list := range mt.subs {
+		if pattern == subject || MatchChannel(pattern, subject) {
+			subs = append(subs, list...)
+		}
+	}
+	return subs
+}
+
+// interested reports whether any of a peer's subscriptions matches subject.
+func interested(patterns map[string]bool, subject string) bool {
+	if patterns[subject] {
+		return true
+	}
+	for pattern := range patterns {
+		if MatchChannel(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mt *MeshTransport) subscribeSubject(subject string, deliver func(subject string, data []byte)) (*asyncSub, error) {
+	mt.mu.Lock()
+	if mt.closed {
+		mt.mu.Unlock()
+		return nil, ErrClosed
+	}
+	s := newAsyncSub(subject, deliver)
+	first := len(mt.subs[subject]) == 0
+	mt.subs[subject] = append(mt.subs[subject], s)
+	peers := mt.connectedLocked()
+	mt.mu.Unlock()
+
+	// Tell peers to start sending us this subject
+	if first {
+		for _, p := range peers {
+			_ = p.send(meshFrame{Kind: frameSub, Subject: subject})
+		}
+	}
+	return s, nil
+}
+
+func (mt *MeshTransport) unsubscribe(s *asyncSub) {
+	s.stop()
+
+	mt.mu.Lock()
+	subs := mt.subs[s.subject]
+	for i, other := range subs {
+		if other == s {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	last := len(subs) == 0
+	if last {
+		delete(mt.subs, s.subject)
+	} else {
+		mt.subs[s.subject] = subs
+	}
+	peers := mt.connectedLocked()
+	mt.mu.Unlock()
+
+	if last {
+		for _, p := range peers {
+			_ = p.send(meshFrame{Kind: frameUnsub, Subject: s.subject})
+		}
+	}
+}
+
+// Send delivers a message to a single node via its inbox channel.
+func (mt *MeshTransport) Send(to string, msg message.Message) error {
+	return mt.Publish(InboxChannel(to), msg)
+}
+
+// SubscribeInbox starts listening to messages sent to this node.
+func (mt *MeshTransport) SubscribeInbox(handler SubscriptionHandler) error {
+	return mt.Subscribe(InboxChannel(mt.id), handler)
+}
+
+// Request publishes a message and waits for the first reply on a private
+// reply subject.
+func (mt *MeshTransport) Request(channel string, msg message.Message, timeout time.Duration) (message.Message, error) {
+	replyTo := fmt.Sprintf("_INBOX.%s.%d", mt.id, mt.requests.Add(1))
+	replies := make(chan message.Message, 1)
+	s, err := mt.subscribeSubject(replyTo, func(_ string, data []byte) {
+		mt.deliver(replyTo, "", data, func(reply message.Message) error {
+			select {
+			case replies <- reply:
+			default: // only the first reply counts
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return message.Message{}, err
+	}
+	defer mt.unsubscribe(s)
+
+	msg.ReplyTo = replyTo
+	n, err := mt.publishSubject(channel, mt.subject(channel), msg)
+	if err != nil {
+		return message.Message{}, err
+	}
+	if n == 0 {
+		return message.Message{}, fmt.Errorf("request on %s: %w", channel, ErrNoResponders)
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-time.After(timeout):
+		return message.Message{}, fmt.Errorf("request on %s: %w", channel, ErrTimeout)
+	}
+}
+
+// Reply answers a message received through Request.
+func (mt *MeshTransport) Reply(req message.Message, resp message.Message) error {
+	if req.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+	if resp.Parent == "" {
+		resp.Follow(req)
+	}
+	resp.To = req.ReplyTo
+
+	// Reply subjects are unique and never namespaced
+	_, err := mt.publishSubject("", req.ReplyTo, resp)
+	return err
+}
+
+// Close stops listening and disconnects from all peers.
+func (mt *MeshTransport) Close() error {
+	mt.mu.Lock()
+	if mt.closed {
+		mt.mu.Unlock()
+		return nil
+	}
+	mt.closed = true
+	close(mt.done)
+	peers := mt.connectedLocked()
+	var subs []*asyncSub
+	for _, s := range mt.subs {
+		subs = append(subs, s...)
+	}
+	mt.subs = make(map[string][]*asyncSub)
+	mt.mu.Unlock()
+
+	err := mt.ln.Close()
+	for _, p := range peers {
+		_ = p.conn.Close()
+	}
+	for _, s := range subs {
+		s.stop()
+	}
+	mt.stopQueues()
+	mt.notifyClosed()
+	return err
+}
+
+func (mt *MeshTransport) connectedLocked() []*meshPeer {
+	peers := make([]*meshPeer, 0, len(mt.peers))
+	for _, p := range mt.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func (mt *MeshTransport) acceptLoop() {
+	for {
+		conn, err := mt.ln.Accept()
+		if err != nil {
+			select {
+			case <-mt.done:
+				return
+			default:
+			}
+			fmt.Printf("[%s] mesh accept failed: %v\n", mt.id, err)
+			time.Sleep(meshDialInterval)
+			continue
+		}
+		go mt.handshake(conn, "")
+	}
+}
+
+// dialLoop keeps a connection open to every known address, reconnecting
+// after failures.
+func (mt *MeshTransport) dialLoop() {
+	ticker := time.NewTicker(meshDialInterval)
+	defer ticker.Stop()
+
+	for {
+		mt.mu.Lock()
+		var addrs []string
+		for addr, id := range mt.dial {
+			if _, connected := mt.peers[id]; id == "" || !connected {
+				addrs = append(addrs, addr)
+			}
+		}
+		mt.mu.Unlock()
+
+		for _, addr := range addrs {
+			conn, err := net.DialTimeout("tcp", addr, meshDialTimeout)
+			if err != nil {
+				continue
+			}
+			go mt.handshake(conn, addr)
+		}
+
+		select {
+		case <-mt.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handshake exchanges hello frames on a new connection and starts serving
+// it. dialed is the address we dialed, or "" for accepted connections.
+func (mt *MeshTransport) handshake(conn net.Conn, dialed string) {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	_ = conn.SetDeadline(time.Now().Add(meshIOTimeout))
+	var hello meshFrame
+	if err := enc.Encode(meshFrame{Kind: frameHello, ID: mt.id, Addr: mt.addr}); err != nil {
+		_ = conn.Close()
+		return
+	}
+	if err := dec.Decode(&hello); err != nil || hello.Kind != frameHello || hello.ID == "" {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if hello.ID == mt.id {
+		// We dialed ourselves, e.g. from a shared static peer list
+		mt.mu.Lock()
+		delete(mt.dial, dialed)
+		mt.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+
+	p := &meshPeer{
+		id:        hello.ID,
+		addr:      advertisedAddr(hello.Addr, conn.RemoteAddr()),
+		initiator: hello.ID,
+		conn:      conn,
+		enc:       enc,
+	}
+	if dialed != "" {
+		p.initiator = mt.id
+	}
+
+	if !mt.register(p, dialed) {
+		_ = conn.Close()
+		return
+	}
+	mt.serve(p, dec)
+}
+
+// register adds a connected peer and sends it our subscriptions and peer
+// list. If both nodes dialed each other, the connection started by the
+// lower ID wins on both sides.
+func (mt *MeshTransport) register(p *meshPeer, dialed string) bool {
+	mt.mu.Lock()
+	if mt.closed {
+		mt.mu.Unlock()
+		return false
+	}
+	if dialed != "" {
+		mt.dial[dialed] = p.id
+	}
+	if old, ok := mt.peers[p.id]; ok {
+		keep := min(mt.id, p.id)
+		if old.initiator == keep && p.initiator != keep {
+			mt.mu.Unlock()
+			return false
+		}
+		_ = old.conn.Close()
+	}
+	mt.peers[p.id] = p
+	mt.interest[p.id] = make(map[string]bool)
+
+	subjects := make([]string, 0, len(mt.subs))
+	for subject := range mt.subs {
+		subjects = append(subjects, subject)
+	}
+	known := make(map[string]string, len(mt.peers))
+	for id, other := range mt.peers {
+		known[id] = other.addr
+	}
+	mt.mu.Unlock()
+
+	for _, subject := range subjects {
+		if err := p.send(meshFrame{Kind: frameSub, Subject: subject}); err != nil {
+			return false
+		}
+	}
+	if err := p.send(meshFrame{Kind: framePeers, Peers: known}); err != nil {
+		return false
+	}
+
+	// Let existing peers learn about the newcomer too
+	mt.mu.Lock()
+	others := mt.connectedLocked()
+	mt.mu.Unlock()
+	for _, other := range others {
+		if other != p {
+			_ = other.send(meshFrame{Kind: framePeers, Peers: map[string]string{p.id: p.addr}})
+		}
+	}
+	return true
+}
+
+// serve reads frames from a peer until its connection fails.
+func (mt *MeshTransport) serve(p *meshPeer, dec *json.Decoder) {
+	defer mt.drop(p)
+
+	for {
+		var f meshFrame
+		if err := dec.Decode(&f); err != nil {
+			return
+		}
+
+		switch f.Kind {
+		case frameSub, frameUnsub:
+			mt.mu.Lock()
+			if mt.peers[p.id] == p {
+				if f.Kind == frameSub {
+					mt.interest[p.id][f.Subject] = true
+				} else {
+					delete(mt.interest[p.id], f.Subject)
+				}
+			}
+			mt.mu.Unlock()
+		case framePeers:
+			mt.learn(f.Peers)
+			// A peer sends its subscriptions before its peer list, so from
+			// here on requests can reach it
+			mt.notifyReconnect()
+		case frameMsg:
+			mt.mu.Lock()
+			subs := mt.localSubsLocked(f.Subject)
+			mt.mu.Unlock()
+			for _, s := range subs {
+				s.push(f.Subject, f.message())
+			}
+		}
+	}
+}
+
+// learn records peer addresses announced by another node.
+func (mt *MeshTransport) learn(peers map[string]string) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	for id, addr := range peers {
+		if id == mt.id || addr == "" {
+			continue
+		}
+		if _, ok := mt.dial[addr]; !ok {
+			mt.dial[addr] = id
+		}
+	}
+}
+
+// drop forgets a peer whose connection failed. Losing the last peer counts
+// as a disconnect.
+func (mt *MeshTransport) drop(p *meshPeer) {
+	_ = p.conn.Close()
+
+	mt.mu.Lock()
+	if mt.peers[p.id] == p {
+		delete(mt.peers, p.id)
+		delete(mt.interest, p.id)
+	}
+	isolated := len(mt.peers) == 0 && !mt.closed
+	mt.mu.Unlock()
+
+	if isolated {
+		mt.notifyDisconnect(fmt.Errorf("lost connection to %s, the last mesh peer", p.id))
+	}
+}
+
+// advertisedAddr fills in the host of a listen address such as ":7001"
+// from the address the peer connected from.
+func advertisedAddr(listen string, remote net.Addr) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if remoteHost, _, err := net.SplitHostPort(remote.String()); err == nil {
+			host = remoteHost
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,174 @@
+package transport
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// newMeshNode starts a mesh node on a free loopback port.
+func newMeshNode(t *testing.T, id string, peers ...string) *MeshTransport {
+	t.Helper()
+	mt, err := NewMeshTransport(id, MeshConfig{Listen: "127.0.0.1:0", Peers: peers})
+	if err != nil {
+		t.Fatalf("start %s: %v", id, err)
+	}
+	t.Cleanup(func() { mt.Close() })
+	return mt
+}
+
+// eventually polls cond until it holds or the test times out.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * meshDialInterval)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// waitPeers waits until mt is connected to exactly the given peers.
+func waitPeers(t *testing.T, mt *MeshTransport, ids ...string) {
+	t.Helper()
+	eventually(t, mt.GetID()+" to connect to "+strings.Join(ids, ","), func() bool {
+		return reflect.DeepEqual(mt.Peers(), ids)
+	})
+}
+
+// waitInterest waits until mt knows that peer subscribes to subject.
+func waitInterest(t *testing.T, mt *MeshTransport, peer, subject string) {
+	t.Helper()
+	eventually(t, mt.GetID()+" to learn "+peer+" subscribes to "+subject, func() bool {
+		mt.mu.Lock()
+		defer mt.mu.Unlock()
+		return interested(mt.interest[peer], subject)
+	})
+}
+
+// notify signals ch without blocking if it was already signalled.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func TestMeshGossipDiscovery(t *testing.T) {
+	// B and C only know the seed A, and learn about each other from it
+	a := newMeshNode(t, "A")
+	b := newMeshNode(t, "B", a.Addr())
+	c := newMeshNode(t, "C", a.Addr())
+
+	waitPeers(t, a, "B", "C")
+	waitPeers(t, b, "A", "C")
+	waitPeers(t, c, "A", "B")
+
+	got := collect(t, c, ChannelFireAlerts)
+	waitInterest(t, b, "C", ChannelFireAlerts)
+	if err := b.Publish(ChannelFireAlerts, message.Message{Type: message.TypeFireAlert}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, got); msg.From != "B" {
+		t.Errorf("got message from %s, want B", msg.From)
+	}
+}
+
+func TestMeshWildcardRouting(t *testing.T) {
+	a := newMeshNode(t, "A")
+	b := newMeshNode(t, "B", a.Addr())
+	waitPeers(t, a, "B")
+
+	water := collect(t, b, "water.*")
+	all := collect(t, b, ">")
+	fires := collect(t, b, "fires.>")
+	waitInterest(t, a, "B", "fires.>")
+
+	if err := a.Publish(ChannelWaterReq, message.Message{Type: message.TypeWaterReq}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, water); msg.Channel != ChannelWaterReq {
+		t.Errorf("water.* got channel %q, want %q", msg.Channel, ChannelWaterReq)
+	}
+	if msg := receive(t, all); msg.Channel != ChannelWaterReq {
+		t.Errorf("> got channel %q, want %q", msg.Channel, ChannelWaterReq)
+	}
+	expectNone(t, fires)
+
+	if err := a.Publish(ChannelFireAlerts, message.Message{Type: message.TypeFireAlert}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, fires); msg.Channel != ChannelFireAlerts {
+		t.Errorf("fires.> got channel %q, want %q", msg.Channel, ChannelFireAlerts)
+	}
+	expectNone(t, water)
+}
+
+func TestMeshRequestReply(t *testing.T) {
+	a := newMeshNode(t, "A")
+	b := newMeshNode(t, "B", a.Addr())
+	waitPeers(t, b, "A")
+
+	if err := a.Subscribe(ChannelWaterSupply, func(req message.Message) error {
+		return a.Reply(req, message.Message{Type: message.TypeWaterResponse})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitInterest(t, b, "A", ChannelWaterSupply)
+
+	resp, err := b.Request(ChannelWaterSupply, message.Message{Type: message.TypeWaterRequest}, waitTime)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if resp.Type != message.TypeWaterResponse || resp.From != "A" {
+		t.Errorf("got %s from %s, want %s from A", resp.Type, resp.From, message.TypeWaterResponse)
+	}
+
+	if _, err := b.Request(ChannelStateQuery, message.Message{Type: message.TypeStateQuery}, waitTime); err == nil {
+		t.Error("Request without responders succeeded")
+	}
+}
+
+func TestMeshPeerReconnects(t *testing.T) {
+	a := newMeshNode(t, "A")
+	b := newMeshNode(t, "B", a.Addr())
+	waitPeers(t, a, "B")
+
+	disconnected := make(chan struct{}, 1)
+	reconnected := make(chan struct{}, 1)
+	b.OnDisconnect(func(error) { notify(disconnected) })
+	b.OnReconnect(func() { notify(reconnected) })
+	got := collect(t, b, ChannelFireAlerts)
+	waitInterest(t, a, "B", ChannelFireAlerts)
+
+	// Cut the connection under both nodes; B dialed A, so B redials
+	a.mu.Lock()
+	conn := a.peers["B"].conn
+	a.mu.Unlock()
+	conn.Close()
+
+	select {
+	case <-disconnected:
+	case <-time.After(waitTime):
+		t.Fatal("B did not report losing its only peer")
+	}
+	waitPeers(t, b, "A")
+	select {
+	case <-reconnected:
+	case <-time.After(waitTime):
+		t.Fatal("B did not report reconnecting")
+	}
+
+	// B's subscriptions are restored on the new connection
+	waitInterest(t, a, "B", ChannelFireAlerts)
+	if err := a.Publish(ChannelFireAlerts, message.Message{Type: message.TypeFireAlert}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, got); msg.From != "A" {
+		t.Errorf("got message from %s, want A", msg.From)
+	}
+}
@@ -1,86 +1,90 @@
 package transport
 
 import (
-	"Firetruck-sim/pkg/clock"
-	"Firetruck-sim/pkg/message"
-	"encoding/json"
 	"fmt"
+	"time"
+
+	"Firetruck-sim/pkg/message"
 
 	"github.com/nats-io/nats.go"
 )
 
 // NATSTransport implements the Transport interface using NATS messaging.
 type NATSTransport struct {
-	id      string
+	*endpoint
 	url     string
-	clock   *clock.LamportClock
 	nc      *nats.Conn
-	sub     *nats.Subscription
-	pubSubs map[string]*nats.Subscription // track pub-sub subscriptions
+	sub     *nats.Subscription   // partition control subscription
+	pubSubs []*nats.Subscription // track pub-sub subscriptions
 }
 
 // NewNATSTransport creates a new NATS transport instance.
-func NewNATSTransport(id, natsURL string) (*NATSTransport, error) {
+func NewNATSTransport(id, natsURL string, opts ...Option) (*NATSTransport, error) {
 	nt := &NATSTransport{
-		id:      id,
-		url:     natsURL,
-		clock:   clock.NewLamportClock(),
-		pubSubs: make(map[string]*nats.Subscription),
+		endpoint: newEndpoint(id, opts),
+		url:      natsURL,
 	}
+	nt.publish = nt.Publish
 
 	nc, err := nats.Connect(natsURL,
 		nats.Name("truck-"+id),
 		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			// Close also reports a disconnect, which is not an outage
+			if !nc.IsClosed() {
+				nt.notifyDisconnect(err)
+			}
+		}),
+		// The client restores every subscription before this runs
+		nats.ReconnectHandler(func(*nats.Conn) {
+			nt.notifyReconnect()
+		}),
+		nats.ClosedHandler(func(*nats.Conn) {
+			nt.notifyClosed()
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 	nt.nc = nc
-	return nt, nil
-}
 
-// GetID returns the transport's unique identifier.
-func (nt *NATSTransport) GetID() string {
-	return nt.id
-}
-
-// SetClock sets the Lamport clock for this transport
-func (nt *NATSTransport) SetClock(clock *clock.LamportClock) {
-	nt.clock = clock
+	// Always listen for partition changes so they can be applied mid-run
+	nt.sub, err = nc.Subscribe(nt.subject(ChannelControlPartition), func(m *nats.Msg) {
+		nt.deliver(ChannelControlPartition, m.Reply, m.Data, nt.handlePartition)
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", ChannelControlPartition, err)
+	}
+	return nt, nil
 }
 
 // Publish broadcasts a message to all subscribers of a channel.
 func (nt *NATSTransport) Publish(channel string, msg message.Message) error {
-	msg.From = nt.id
-	// Only set Lamport if not already set by caller
-	if msg.Lamport == 0 {
-		msg.Lamport = nt.clock.Tick()
+	data, err := nt.encode(channel, msg)
+	if err != nil {
+		return err
 	}
 
-	data, err := json.Marshal(msg)
+	return nt.nc.Publish(nt.subject(channel), data)
+}
+
+// Republish publishes msg as recorded elsewhere, keeping its sender,
+// timestamp, sequence number and, unless it expires, signature.
+func (nt *NATSTransport) Republish(channel string, msg message.Message) error {
+	data, err := nt.encodeReplay(channel, msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal broadcast message: %w", err)
+		return err
 	}
 
-	return nt.nc.Publish(channel, data)
+	return nt.nc.Publish(nt.subject(channel), data)
 }
 
 // Subscribe starts listening to broadcast messages on a channel.
 func (nt *NATSTransport) Subscribe(channel string, handler SubscriptionHandler) error {
-	sub, err := nt.nc.Subscribe(channel, func(m *nats.Msg) {
-		var msg message.Message
-		if err := json.Unmarshal(m.Data, &msg); err != nil {
-			fmt.Printf("Error unmarshaling broadcast message: %v\n", err)
-			return
-		}
-
-		// Update Lamport clock
-		nt.clock.Receive(msg.Lamport)
-
-		// Call handler
-		if err := handler(msg); err != nil {
-			fmt.Printf("Error handling broadcast message: %v\n", err)
-		}
+	handler = nt.prepare(channel, handler)
+	sub, err := nt.nc.Subscribe(nt.subject(channel), func(m *nats.Msg) {
+		nt.deliver(nt.channelOf(m.Subject), m.Reply, m.Data, handler)
 	})
 
 	if err != nil {
@@ -88,10 +92,85 @@ func (nt *NATSTransport) Subscribe(channel string, handler SubscriptionHandler)
 	}
 
 	// Store subscription for cleanup
-	nt.pubSubs[channel] = sub
+	nt.pubSubs = append(nt.pubSubs, sub)
 	return nt.nc.Flush()
 }
 
+// Send delivers a message to a single node via its inbox subject.
+func (nt *NATSTransport) Send(to string, msg message.Message) error {
+	return nt.Publish(InboxChannel(to), msg)
+}
+
+// SubscribeInbox starts listening to messages sent to this node.
+func (nt *NATSTransport) SubscribeInbox(handler SubscriptionHandler) error {
+	return nt.Subscribe(InboxChannel(nt.id), handler)
+}
+
+// Request publishes a message with a private NATS reply inbox and waits
+// for the first reply that passes the same checks as any delivery, so a
+// reply from across a partition is lost as on the other transports.
+func (nt *NATSTransport) Request(channel string, msg message.Message, timeout time.Duration) (message.Message, error) {
+	replyTo := nt.nc.NewInbox()
+	replies := make(chan message.Message, 1)
+	noResponders := make(chan struct{}, 1)
+	sub, err := nt.nc.Subscribe(replyTo, func(m *nats.Msg) {
+		// The server answers with an empty 503 status if nobody listens
+		if len(m.Data) == 0 && m.Header.Get("Status") == "503" {
+			select {
+			case noResponders <- struct{}{}:
+			default:
+			}
+			return
+		}
+		nt.deliver(replyTo, "", m.Data, func(reply message.Message) error {
+			select {
+			case replies <- reply:
+			default: // only the first reply counts
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return message.Message{}, fmt.Errorf("request on %s failed: %w", channel, err)
+	}
+	defer sub.Unsubscribe()
+
+	data, err := nt.encode(channel, msg)
+	if err != nil {
+		return message.Message{}, err
+	}
+	if err := nt.nc.PublishRequest(nt.subject(channel), replyTo, data); err != nil {
+		return message.Message{}, fmt.Errorf("request on %s failed: %w", channel, err)
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil // clock already updated on delivery
+	case <-noResponders:
+		return message.Message{}, fmt.Errorf("request on %s: %w", channel, ErrNoResponders)
+	case <-time.After(timeout):
+		return message.Message{}, fmt.Errorf("request on %s: %w", channel, ErrTimeout)
+	}
+}
+
+// Reply answers a message received through Request.
+func (nt *NATSTransport) Reply(req message.Message, resp message.Message) error {
+	if req.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+	if resp.Parent == "" {
+		resp.Follow(req)
+	}
+	resp.To = req.ReplyTo
+
+	// Reply inboxes are unique subjects and are never namespaced
+	data, err := nt.encode("", resp)
+	if err != nil {
+		return err
+	}
+	return nt.nc.Publish(req.ReplyTo, data)
+}
+
 // Close shuts down the NATS transport.
 func (nt *NATSTransport) Close() error {
 	// Unsubscribe from all pub-sub channels
@@ -105,5 +184,6 @@ func (nt *NATSTransport) Close() error {
 	if nt.nc != nil {
 		nt.nc.Close()
 	}
+	nt.stopQueues()
 	return nil
 }
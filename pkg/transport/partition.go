@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// PartitionTable records which group each node belongs to during a
+// simulated network partition. Nodes in different groups cannot hear each
+// other; nodes not listed in any group can reach everyone.
+type PartitionTable struct {
+	mu    sync.RWMutex
+	group map[string]int
+}
+
+// NewPartitionTable creates a healed (fully connected) partition table.
+func NewPartitionTable() *PartitionTable {
+	return &PartitionTable{group: make(map[string]int)}
+}
+
+// Set replaces the current partition with the given groups.
+func (p *PartitionTable) Set(groups [][]string) {
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			group[id] = i
+		}
+	}
+
+	p.mu.Lock()
+	p.group = group
+	p.mu.Unlock()
+}
+
+// Heal removes all partitions.
+func (p *PartitionTable) Heal() {
+	p.Set(nil)
+}
+
+// Reachable reports whether a message from one node may reach another.
+func (p *PartitionTable) Reachable(from, to string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	gf, okFrom := p.group[from]
+	gt, okTo := p.group[to]
+	return !okFrom || !okTo || gf == gt
+}
+
+// ParsePartition parses a partition such as "{T1,T2} | {T3,OBSERVER}" into
+// node groups. Braces are optional and an empty spec means no partition.
+func ParsePartition(spec string) ([][]string, error) {
+	var groups [][]string
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(spec, "|") {
+		part = strings.TrimSpace(part)
+		part = strings.TrimPrefix(part, "{")
+		part = strings.TrimSuffix(part, "}")
+
+		var group []string
+		for _, id := range strings.Split(part, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("node %s appears in more than one partition group", id)
+			}
+			seen[id] = true
+			group = append(group, id)
+		}
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// FormatPartition renders groups in the same form ParsePartition accepts.
+func FormatPartition(groups [][]string) string {
+	if len(groups) == 0 {
+		return "healed"
+	}
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		parts[i] = "{" + strings.Join(g, ",") + "}"
+	}
+	return strings.Join(parts, " | ")
+}
+
+// PartitionMessage builds the control message that installs a partition.
+// An empty spec heals the network.
+func PartitionMessage(spec string) (message.Message, error) {
+	return message.New(message.TypePartition, "", message.Partition{Spec: spec})
+}
@@ -0,0 +1,231 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// OverflowPolicy decides what happens when a delivery queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the receiving side wait for space
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message
+	OverflowDropOldest
+	// OverflowDropNewest discards the message that just arrived
+	OverflowDropNewest
+)
+
+// ParseOverflowPolicy parses "block", "drop-oldest" or "drop-newest".
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "block":
+		return OverflowBlock, nil
+	case "drop-oldest":
+		return OverflowDropOldest, nil
+	case "drop-newest":
+		return OverflowDropNewest, nil
+	default:
+		return 0, fmt.Errorf("unknown overflow policy %q. Valid policies: block, drop-oldest, drop-newest", s)
+	}
+}
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	default:
+		return "block"
+	}
+}
+
+// QueueConfig configures bounded delivery queues.
+type QueueConfig struct {
+	Size   int            // capacity of each queue
+	Policy OverflowPolicy // what to do when a queue is full
+	Shared bool           // one queue and worker for the whole node
+}
+
+// QueueStats describes one delivery queue.
+type QueueStats struct {
+	Name      string // channel, or "shared" for the node-wide queue
+	Depth     int
+	MaxDepth  int
+	Capacity  int
+	Delivered uint64
+	Dropped   uint64
+}
+
+// WithDeliveryQueue runs subscription handlers from bounded queues, each
+// drained by a single worker. With one queue per subscription, messages
+// from each sender reach a handler in the order they arrived; with a shared
+// queue every handler of the node also runs one at a time, so handlers may
+// touch shared state without locks.
+func WithDeliveryQueue(cfg QueueConfig) Option {
+	return func(e *endpoint) {
+		e.queueCfg = &cfg
+		if cfg.Shared {
+			e.sharedQueue = newDeliveryQueue("shared", cfg)
+			e.queues = append(e.queues, e.sharedQueue)
+		}
+	}
+}
+
+type queuedMsg struct {
+	msg     message.Message
+	handler SubscriptionHandler
+}
+
+// deliveryQueue is a bounded FIFO of messages with a single worker.
+type deliveryQueue struct {
+	name   string
+	size   int
+	policy OverflowPolicy
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []queuedMsg
+	closed   bool
+
+	maxDepth  int
+	delivered uint64
+	dropped   uint64
+}
+
+// newDeliveryQueue creates a queue and starts its worker.
+func newDeliveryQueue(name string, cfg QueueConfig) *deliveryQueue {
+	size := cfg.Size
+	if size <= 0 {
+		size = 1
+	}
+	q := &deliveryQueue{name: name, size: size, policy: cfg.Policy}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+func (q *deliveryQueue) push(item queuedMsg) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) >= q.size && !q.closed {
+		switch q.policy {
+		case OverflowDropNewest:
+			q.dropped++
+			return
+		case OverflowDropOldest:
+			q.items = q.items[1:]
+			q.dropped++
+		default:
+			q.notFull.Wait()
+		}
+	}
+	if q.closed {
+		return
+	}
+
+	q.items = append(q.items, item)
+	if len(q.items) > q.maxDepth {
+		q.maxDepth = len(q.items)
+	}
+	q.notEmpty.Signal()
+}
+
+func (q *deliveryQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.notEmpty.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		item := q.items[0]
+		q.items = q.items[1:]
+		q.notFull.Signal()
+		q.mu.Unlock()
+
+		if err := item.handler(item.msg); err != nil {
+			fmt.Printf("Error handling broadcast message: %v\n", err)
+		}
+
+		q.mu.Lock()
+		q.delivered++
+		q.mu.Unlock()
+	}
+}
+
+func (q *deliveryQueue) stop() {
+	q.mu.Lock()
+	q.closed = true
+	q.items = nil
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *deliveryQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{
+		Name:      q.name,
+		Depth:     len(q.items),
+		MaxDepth:  q.maxDepth,
+		Capacity:  q.size,
+		Delivered: q.delivered,
+		Dropped:   q.dropped,
+	}
+}
+
+// queued returns a handler that hands messages for channel to a delivery
+// queue instead of running handler directly. Without WithDeliveryQueue it
+// returns handler unchanged.
+func (e *endpoint) queued(channel string, handler SubscriptionHandler) SubscriptionHandler {
+	if e.queueCfg == nil {
+		return handler
+	}
+
+	q := e.sharedQueue
+	if q == nil {
+		q = newDeliveryQueue(channel, *e.queueCfg)
+		e.queueMu.Lock()
+		e.queues = append(e.queues, q)
+		e.queueMu.Unlock()
+	}
+	return func(msg message.Message) error {
+		q.push(queuedMsg{msg: msg, handler: handler})
+		return nil
+	}
+}
+
+// QueueStats reports the depth and counters of every delivery queue.
+func (e *endpoint) QueueStats() []QueueStats {
+	e.queueMu.Lock()
+	defer e.queueMu.Unlock()
+
+	stats := make([]QueueStats, len(e.queues))
+	for i, q := range e.queues {
+		stats[i] = q.stats()
+	}
+	return stats
+}
+
+// stopQueues stops every delivery queue worker, discarding pending messages.
+func (e *endpoint) stopQueues() {
+	e.queueMu.Lock()
+	queues := e.queues
+	e.queueMu.Unlock()
+
+	for _, q := range queues {
+		q.stop()
+	}
+}
@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"sort"
+	"sync"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// maxMissing bounds how many missing sequence numbers are remembered per
+// stream. Older ones are given up on and a late copy counts as a duplicate.
+const maxMissing = 1024
+
+// SeqStats describes the messages received from one peer on one channel.
+type SeqStats struct {
+	Peer       string
+	Channel    string
+	Received   uint64 // distinct messages delivered
+	Duplicates uint64 // replays, dropped unless injected as faults
+	Gaps       uint64 // sequence numbers skipped over
+	Late       uint64 // skipped messages that arrived after all
+}
+
+// Missing returns how many skipped messages never arrived.
+func (s SeqStats) Missing() uint64 {
+	return s.Gaps - s.Late
+}
+
+type seqKey struct {
+	sub           int
+	peer, channel string
+}
+
+// seqStream tracks the sequence numbers of one sender on one channel, as
+// seen by one subscription.
+type seqStream struct {
+	epoch   int64
+	highest uint64
+	missing map[uint64]bool
+	stats   SeqStats
+}
+
+// SeqTracker watches per-sender sequence numbers to drop duplicates and
+// count messages lost in transit. Messages without a sequence number, such
+// as replies, pass through untracked.
+type SeqTracker struct {
+	mu      sync.Mutex
+	subs    int
+	streams map[seqKey]*seqStream
+}
+
+// NewSeqTracker creates an empty tracker.
+func NewSeqTracker() *SeqTracker {
+	return &SeqTracker{streams: make(map[seqKey]*seqStream)}
+}
+
+// Filter returns a handler that drops replays before they reach handler.
+// Each subscription keeps its own view, since every subscription on a
+// channel receives its own copy of each message. Messages are tracked under
+// the channel they arrived on, falling back to channel.
+func (t *SeqTracker) Filter(channel string, handler SubscriptionHandler) SubscriptionHandler {
+	return t.track(channel, handler, true)
+}
+
+// Watch returns a handler that counts replays and losses like Filter but
+// passes every message on to handler, replays included.
+func (t *SeqTracker) Watch(channel string, handler SubscriptionHandler) SubscriptionHandler {
+	return t.track(channel, handler, false)
+}
+
+func (t *SeqTracker) track(channel string, handler SubscriptionHandler, drop bool) SubscriptionHandler {
+	t.mu.Lock()
+	t.subs++
+	sub := t.subs
+	t.mu.Unlock()
+
+	return func(msg message.Message) error {
+		ch := msg.Channel
+		if ch == "" {
+			ch = channel
+		}
+		if !t.observe(sub, ch, msg) && drop {
+			return nil
+		}
+		return handler(msg)
+	}
+}
+
+// observe records msg and reports whether it is new to the subscription.
+func (t *SeqTracker) observe(sub int, channel string, msg message.Message) bool {
+	if msg.Seq == 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := seqKey{sub, msg.From, channel}
+	s := t.streams[key]
+	switch {
+	case s == nil:
+		// First message from a sender that may have been running before
+		// we subscribed; its earlier messages were never ours to lose
+		s = &seqStream{
+			epoch:   msg.Epoch,
+			highest: msg.Seq - 1,
+			missing: make(map[uint64]bool),
+			stats:   SeqStats{Peer: msg.From, Channel: channel},
+		}
+		t.streams[key] = s
+	case msg.Epoch > s.epoch:
+		// The sender restarted and counts from 1 again
+		s = &seqStream{epoch: msg.Epoch, missing: make(map[uint64]bool), stats: s.stats}
+		t.streams[key] = s
+	}
+	if msg.Epoch < s.epoch {
+		// Left over from before the sender restarted
+		s.stats.Duplicates++
+		return false
+	}
+
+	switch {
+	case msg.Seq > s.highest:
+		for seq := s.highest + 1; seq < msg.Seq; seq++ {
+			s.stats.Gaps++
+			if len(s.missing) < maxMissing {
+				s.missing[seq] = true
+			}
+		}
+		s.highest = msg.Seq
+	case s.missing[msg.Seq]:
+		delete(s.missing, msg.Seq)
+		s.stats.Late++
+	default:
+		s.stats.Duplicates++
+		return false
+	}
+
+	s.stats.Received++
+	return true
+}
+
+// Stats returns the counters for every peer and channel, ordered by peer
+// and channel. When several subscriptions share a channel, each counter is
+// the highest any of them saw.
+func (t *SeqTracker) Stats() []SeqStats {
+	t.mu.Lock()
+	merged := make(map[seqKey]SeqStats)
+	for key, s := range t.streams {
+		key.sub = 0
+		m, ok := merged[key]
+		if !ok {
+			merged[key] = s.stats
+			continue
+		}
+		m.Received = max(m.Received, s.stats.Received)
+		m.Duplicates = max(m.Duplicates, s.stats.Duplicates)
+		m.Gaps = max(m.Gaps, s.stats.Gaps)
+		m.Late = max(m.Late, s.stats.Late)
+		merged[key] = m
+	}
+	t.mu.Unlock()
+
+	stats := make([]SeqStats, 0, len(merged))
+	for _, s := range merged {
+		stats = append(stats, s)
+	}
+	sortSeqStats(stats)
+	return stats
+}
+
+func sortSeqStats(stats []SeqStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Peer != stats[j].Peer {
+			return stats[i].Peer < stats[j].Peer
+		}
+		return stats[i].Channel < stats[j].Channel
+	})
+}
+
+// nextSeq returns the next sequence number for messages this node sends
+// on channel.
+func (e *endpoint) nextSeq(channel string) uint64 {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	e.seqOut[channel]++
+	return e.seqOut[channel]
+}
+
+// SeqStats reports duplicates and gaps seen per peer and channel.
+func (e *endpoint) SeqStats() []SeqStats {
+	return e.seqIn.Stats()
+}
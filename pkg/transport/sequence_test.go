@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"testing"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// observeSeqs feeds messages with the given sequence numbers from one
+// sender through a filter and returns how many got through.
+func observeSeqs(tracker *SeqTracker, epoch int64, seqs ...uint64) int {
+	delivered := 0
+	handler := tracker.Filter(ChannelFireAlerts, func(message.Message) error {
+		delivered++
+		return nil
+	})
+	for _, seq := range seqs {
+		_ = handler(message.Message{From: "T1", Seq: seq, Epoch: epoch})
+	}
+	return delivered
+}
+
+func seqStatsOf(t *testing.T, tracker *SeqTracker) SeqStats {
+	t.Helper()
+	stats := tracker.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("got %d streams, want 1", len(stats))
+	}
+	return stats[0]
+}
+
+func TestSeqTrackerGapsAndDuplicates(t *testing.T) {
+	tracker := NewSeqTracker()
+	if n := observeSeqs(tracker, 1, 1, 2, 2, 5, 4, 4); n != 4 {
+		t.Errorf("delivered %d messages, want 4", n)
+	}
+
+	s := seqStatsOf(t, tracker)
+	if s.Received != 4 || s.Duplicates != 2 || s.Gaps != 2 || s.Late != 1 || s.Missing() != 1 {
+		t.Errorf("got %+v missing=%d, want 4 received, 2 duplicates, 2 gaps, 1 late, 1 missing", s, s.Missing())
+	}
+}
+
+func TestSeqTrackerLateJoiner(t *testing.T) {
+	// We subscribed after the sender had already sent 10 messages
+	tracker := NewSeqTracker()
+	if n := observeSeqs(tracker, 1, 11, 12, 14); n != 3 {
+		t.Errorf("delivered %d messages, want 3", n)
+	}
+
+	s := seqStatsOf(t, tracker)
+	if s.Gaps != 1 || s.Missing() != 1 {
+		t.Errorf("got %d gaps, %d missing, want only 13 missing", s.Gaps, s.Missing())
+	}
+}
+
+func TestSeqTrackerSenderRestart(t *testing.T) {
+	tracker := NewSeqTracker()
+	handler := tracker.Filter(ChannelFireAlerts, func(message.Message) error { return nil })
+	for _, msg := range []message.Message{
+		{From: "T1", Seq: 7, Epoch: 1},
+		{From: "T1", Seq: 2, Epoch: 2}, // restarted, and 1 was lost
+		{From: "T1", Seq: 8, Epoch: 1}, // from before the restart
+	} {
+		_ = handler(msg)
+	}
+
+	s := seqStatsOf(t, tracker)
+	if s.Received != 2 || s.Gaps != 1 || s.Duplicates != 1 {
+		t.Errorf("got %+v, want 2 received, 1 gap, 1 duplicate", s)
+	}
+}
@@ -0,0 +1,377 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// snapshotWait bounds how long a node waits for the markers of its peers
+// before reporting, and an initiator twice that for the reports.
+const snapshotWait = 3 * time.Second
+
+// snapshotSettle is how long an initiator keeps listening for markers
+// before it may conclude that every node has reported.
+const snapshotSettle = time.Second
+
+// StateFunc returns the local state a node records in a snapshot. It never
+// runs concurrently with a subscription handler, even one run from a
+// delivery queue, since the queue sits below the snapshot layer. State that
+// other goroutines change needs a lock of its own.
+type StateFunc func() interface{}
+
+// ChannelMessage is a message in flight on a channel.
+type ChannelMessage struct {
+	Channel string          `json:"channel"`
+	Message message.Message `json:"msg"`
+}
+
+// NodeSnapshot is the part of a global snapshot recorded by one node.
+type NodeSnapshot struct {
+	Node     string           `json:"node"`
+	State    interface{}      `json:"state,omitempty"`
+	InFlight []ChannelMessage `json:"in_flight,omitempty"` // sent before the cut, received after it
+	Missing  []string         `json:"missing,omitempty"`   // peers whose marker never arrived
+}
+
+// GlobalSnapshot is a consistent cut of the system: the state of every
+// node and the messages in flight between them.
+type GlobalSnapshot struct {
+	ID        uint64         `json:"id"`
+	Initiator string         `json:"initiator"`
+	Time      time.Time      `json:"time"`
+	Nodes     []NodeSnapshot `json:"nodes"` // ordered by node
+}
+
+// SnapshotTransport wraps a Transport with the Chandy–Lamport snapshot
+// algorithm. A node records its state when it starts a snapshot or first
+// hears of one, then broadcasts a marker on ChannelSnapshotMarker. Since
+// channels here are not FIFO, every message also carries the latest
+// snapshot its sender recorded: a message from after the cut makes the
+// receiver record first, and one from before the cut that arrives after
+// the receiver recorded was in flight. Once every peer's marker is in, or
+// after snapshotWait, each node reports on ChannelSnapshotReport.
+type SnapshotTransport struct {
+	Transport
+
+	deliverMu sync.RWMutex // held by handlers, exclusively while recording
+
+	mu      sync.Mutex
+	state   StateFunc
+	current uint64          // latest snapshot recorded
+	peers   map[string]bool // nodes heard from
+	run     *snapshotRun
+	collect *snapshotCollect
+}
+
+// snapshotRun is this node's part in one snapshot.
+type snapshotRun struct {
+	id       uint64
+	node     NodeSnapshot
+	peers    map[string]bool // markers still expected
+	inFlight map[string]bool // messages already recorded, by orderID
+	done     bool
+}
+
+// snapshotCollect gathers the reports of a snapshot this node started.
+type snapshotCollect struct {
+	id       uint64
+	expected map[string]bool // nodes known to take part
+	reports  map[string]NodeSnapshot
+}
+
+// NewSnapshotTransport wraps inner with snapshot support. Nodes record
+// nothing but in-flight messages until SetState is called.
+func NewSnapshotTransport(inner Transport) (*SnapshotTransport, error) {
+	st := &SnapshotTransport{
+		Transport: inner,
+		peers:     make(map[string]bool),
+	}
+	if err := inner.Subscribe(ChannelSnapshotMarker, st.receiveMarker); err != nil {
+		return nil, err
+	}
+	if err := inner.Subscribe(ChannelSnapshotReport, st.receiveReport); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// SetState sets the function that records this node's local state.
+func (st *SnapshotTransport) SetState(state StateFunc) {
+	st.mu.Lock()
+	st.state = state
+	st.mu.Unlock()
+}
+
+// Publish marks msg with the latest recorded snapshot.
+func (st *SnapshotTransport) Publish(channel string, msg message.Message) error {
+	msg.Snapshot = st.latest()
+	return st.Transport.Publish(channel, msg)
+}
+
+// Send marks msg with the latest recorded snapshot.
+func (st *SnapshotTransport) Send(to string, msg message.Message) error {
+	msg.Snapshot = st.latest()
+	return st.Transport.Send(to, msg)
+}
+
+// Request marks msg with the latest recorded snapshot.
+func (st *SnapshotTransport) Request(channel string, msg message.Message, timeout time.Duration) (message.Message, error) {
+	msg.Snapshot = st.latest()
+	return st.Transport.Request(channel, msg, timeout)
+}
+
+// Subscribe listens on channel, recording in-flight messages.
+func (st *SnapshotTransport) Subscribe(channel string, handler SubscriptionHandler) error {
+	return st.Transport.Subscribe(channel, st.wrap(handler))
+}
+
+// SubscribeInbox listens for direct messages, recording in-flight messages.
+func (st *SnapshotTransport) SubscribeInbox(handler SubscriptionHandler) error {
+	return st.Transport.SubscribeInbox(st.wrap(handler))
+}
+
+// Snapshot starts a global snapshot and waits for the nodes to report.
+func (st *SnapshotTransport) Snapshot() (*GlobalSnapshot, error) {
+	id := uint64(time.Now().UnixNano())
+	c := &snapshotCollect{
+		id:       id,
+		expected: make(map[string]bool),
+		reports:  make(map[string]NodeSnapshot),
+	}
+	st.mu.Lock()
+	if st.state != nil {
+		c.expected[st.GetID()] = true
+	}
+	st.collect = c
+	st.mu.Unlock()
+
+	start := time.Now()
+	st.record(id)
+
+	ticker := time.NewTicker(snapshotWait / 30)
+	defer ticker.Stop()
+	for range ticker.C {
+		if time.Since(start) > 2*snapshotWait {
+			break
+		}
+		if time.Since(start) > snapshotSettle && st.reported(c) {
+			break
+		}
+	}
+
+	st.mu.Lock()
+	st.collect = nil
+	snap := &GlobalSnapshot{ID: id, Initiator: st.GetID(), Time: time.Unix(0, int64(id))}
+	for _, node := range c.reports {
+		snap.Nodes = append(snap.Nodes, node)
+	}
+	st.mu.Unlock()
+
+	if len(snap.Nodes) == 0 {
+		return nil, fmt.Errorf("no node reported within %v", 2*snapshotWait)
+	}
+	sort.Slice(snap.Nodes, func(i, j int) bool { return snap.Nodes[i].Node < snap.Nodes[j].Node })
+	return snap, nil
+}
+
+// reported tells whether every node known to take part in c has reported.
+func (st *SnapshotTransport) reported(c *snapshotCollect) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id := range c.expected {
+		if _, ok := c.reports[id]; !ok {
+			return false
+		}
+	}
+	return len(c.reports) > 0
+}
+
+// latest returns the latest snapshot this node recorded.
+func (st *SnapshotTransport) latest() uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.current
+}
+
+// wrap records the state before handling a message sent after the cut, and
+// the message itself if it was in flight.
+func (st *SnapshotTransport) wrap(handler SubscriptionHandler) SubscriptionHandler {
+	return func(msg message.Message) error {
+		for {
+			st.deliverMu.RLock()
+			if st.observe(msg) {
+				break
+			}
+			st.deliverMu.RUnlock()
+			st.record(msg.Snapshot)
+		}
+		defer st.deliverMu.RUnlock()
+		return handler(msg)
+	}
+}
+
+// observe notes the sender of msg and records it if it was in flight. It
+// returns false if msg was sent after a cut this node has not recorded.
+func (st *SnapshotTransport) observe(msg message.Message) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if msg.From == st.GetID() || msg.Channel == ChannelRuns {
+		// Run announcements also come from other runs, whose snapshots
+		// have nothing to do with ours
+		return true
+	}
+	if msg.Snapshot > st.current {
+		return false
+	}
+	st.peers[msg.From] = true
+
+	run := st.run
+	if run == nil || run.done || msg.Snapshot == run.id {
+		return true
+	}
+	if msg.Seq != 0 {
+		// Every subscription on the channel sees the message
+		id := orderID(msg)
+		if run.inFlight[id] {
+			return true
+		}
+		run.inFlight[id] = true
+	}
+	run.node.InFlight = append(run.node.InFlight, ChannelMessage{Channel: msg.Channel, Message: msg})
+	return true
+}
+
+// record records the local state for snapshot id, unless it already has,
+// and broadcasts a marker. A newer snapshot replaces an unfinished one.
+func (st *SnapshotTransport) record(id uint64) {
+	st.deliverMu.Lock()
+	st.mu.Lock()
+	if id <= st.current {
+		st.mu.Unlock()
+		st.deliverMu.Unlock()
+		return
+	}
+	st.current = id
+	run := &snapshotRun{
+		id:       id,
+		node:     NodeSnapshot{Node: st.GetID()},
+		peers:    make(map[string]bool),
+		inFlight: make(map[string]bool),
+	}
+	for peer := range st.peers {
+		run.peers[peer] = true
+	}
+	st.run = run
+	state := st.state
+	st.mu.Unlock()
+
+	if state != nil {
+		run.node.State = state()
+	}
+	st.deliverMu.Unlock()
+
+	marker := message.NewMessage(message.TypeSnapshotMarker, st.GetID(), nil)
+	marker.Snapshot = id
+	if err := st.Transport.Publish(ChannelSnapshotMarker, marker); err != nil {
+		fmt.Printf("Failed to send snapshot marker: %v\n", err)
+	}
+
+	time.AfterFunc(snapshotWait, func() { st.finish(run) })
+	if len(run.peers) == 0 {
+		st.finish(run)
+	}
+}
+
+// receiveMarker records the state on the first marker of a snapshot and
+// reports once every peer's marker is in.
+func (st *SnapshotTransport) receiveMarker(msg message.Message) error {
+	if msg.Type != message.TypeSnapshotMarker || msg.From == st.GetID() || msg.Snapshot == 0 {
+		return nil
+	}
+	st.record(msg.Snapshot)
+
+	st.mu.Lock()
+	if c := st.collect; c != nil && c.id == msg.Snapshot {
+		c.expected[msg.From] = true
+	}
+	run := st.run
+	if run == nil || run.id != msg.Snapshot {
+		st.mu.Unlock()
+		return nil
+	}
+	delete(run.peers, msg.From)
+	complete := len(run.peers) == 0
+	st.mu.Unlock()
+
+	if complete {
+		st.finish(run)
+	}
+	return nil
+}
+
+// finish reports this node's part in a snapshot, once.
+func (st *SnapshotTransport) finish(run *snapshotRun) {
+	st.mu.Lock()
+	if run.done || st.run != run {
+		st.mu.Unlock()
+		return
+	}
+	run.done = true
+	node := run.node
+	for peer := range run.peers {
+		node.Missing = append(node.Missing, peer)
+	}
+	st.mu.Unlock()
+	sort.Strings(node.Missing)
+
+	data, err := json.Marshal(node)
+	if err != nil {
+		fmt.Printf("Failed to encode snapshot: %v\n", err)
+		return
+	}
+	report, err := message.New(message.TypeSnapshotReport, st.GetID(), message.SnapshotReport{Node: data})
+	if err != nil {
+		fmt.Printf("Failed to encode snapshot: %v\n", err)
+		return
+	}
+	report.Snapshot = run.id
+	if err := st.Transport.Publish(ChannelSnapshotReport, report); err != nil {
+		fmt.Printf("Failed to send snapshot report: %v\n", err)
+	}
+}
+
+// receiveReport collects reports for the snapshot this node started.
+func (st *SnapshotTransport) receiveReport(msg message.Message) error {
+	if msg.Type != message.TypeSnapshotReport {
+		return nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	c := st.collect
+	if c == nil || c.id != msg.Snapshot {
+		return nil
+	}
+	if msg.From == st.GetID() && !c.expected[msg.From] {
+		// An initiator without state of its own
+		return nil
+	}
+
+	report, err := message.Decode[message.SnapshotReport](msg)
+	if err != nil {
+		return err
+	}
+	var node NodeSnapshot
+	if err := json.Unmarshal(report.Node, &node); err != nil {
+		return fmt.Errorf("failed to decode snapshot report: %w", err)
+	}
+	c.reports[msg.From] = node
+	c.expected[msg.From] = true
+	return nil
+}
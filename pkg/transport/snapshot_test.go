@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+func TestSnapshotIgnoresOtherRuns(t *testing.T) {
+	bus := NewMemBus()
+	st, err := NewSnapshotTransport(NewMemTransport("T1", bus, WithNamespace("ours")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	peer := NewMemTransport("T2", bus, WithNamespace("ours"))
+	defer peer.Close()
+	other := NewMemTransport("T1", bus, WithNamespace("theirs"))
+	defer other.Close()
+
+	runs := collect(t, st, ChannelRuns)
+	markers := collect(t, peer, ChannelSnapshotMarker)
+
+	// A node of another run that has taken a snapshot announces itself
+	if err := other.Publish(ChannelRuns, message.Message{Type: message.TypeRunAnnounce, Snapshot: 42}); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, runs)
+
+	expectNone(t, markers)
+	if id := st.latest(); id != 0 {
+		t.Errorf("recorded snapshot %d for another run's announcement", id)
+	}
+}
+
+func TestSnapshotRecordsOnLaterMessage(t *testing.T) {
+	bus := NewMemBus()
+	st, err := NewSnapshotTransport(NewMemTransport("T1", bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	peer := NewMemTransport("T2", bus)
+	defer peer.Close()
+
+	alerts := collect(t, st, ChannelFireAlerts)
+	markers := collect(t, peer, ChannelSnapshotMarker)
+
+	// A message sent after the cut makes the receiver record first
+	if err := peer.Publish(ChannelFireAlerts, message.Message{Type: message.TypeFireAlert, Snapshot: 42}); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, alerts)
+
+	if marker := receive(t, markers); marker.From != "T1" || marker.Snapshot != 42 {
+		t.Errorf("got marker for snapshot %d from %s, want 42 from T1", marker.Snapshot, marker.From)
+	}
+	if id := st.latest(); id != 42 {
+		t.Errorf("latest snapshot = %d, want 42", id)
+	}
+}
+
+func TestSnapshotStateWaitsForQueuedHandlers(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		bus := NewMemBus()
+		st, err := NewSnapshotTransport(NewMemTransport("T1", bus,
+			WithDeliveryQueue(QueueConfig{Size: 8, Shared: shared})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer := NewMemTransport("T2", bus)
+
+		// The handler is still busy with an alert when a message from after
+		// the cut arrives on another channel
+		var busy atomic.Bool
+		handled := make(chan struct{}, 1)
+		if err := st.Subscribe(ChannelFireAlerts, func(msg message.Message) error {
+			busy.Store(true)
+			time.Sleep(100 * time.Millisecond)
+			busy.Store(false)
+			handled <- struct{}{}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		statuses := collect(t, st, ChannelTruckStatus)
+		recorded := make(chan bool, 1)
+		st.SetState(func() interface{} {
+			recorded <- busy.Load()
+			return nil
+		})
+
+		if err := peer.Publish(ChannelFireAlerts, message.Message{Type: message.TypeFireAlert}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if err := peer.Publish(ChannelTruckStatus, message.Message{Type: message.TypeTruckStatus, Snapshot: 42}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case duringHandler := <-recorded:
+			if duringHandler {
+				t.Errorf("shared=%v: state recorded while a queued handler was running", shared)
+			}
+		case <-time.After(waitTime):
+			t.Fatalf("shared=%v: state never recorded", shared)
+		}
+		<-handled
+		receive(t, statuses)
+		st.Close()
+		peer.Close()
+	}
+}
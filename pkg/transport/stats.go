@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// ReplyStatsChannel is the channel name replies are counted under, since
+// every request gets its own reply subject.
+const ReplyStatsChannel = "(reply)"
+
+// LatencyBuckets are the upper bounds of the handler latency histogram.
+// Slower handlers fall into a final overflow bucket.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Histogram counts handler run times per LatencyBuckets entry, plus one
+// overflow bucket at the end.
+type Histogram struct {
+	Counts []uint64
+	Count  uint64
+	Total  time.Duration
+}
+
+// Mean returns the average handler run time.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(h.Count)
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := sort.Search(len(LatencyBuckets), func(i int) bool { return d <= LatencyBuckets[i] })
+	h.Counts[i]++
+	h.Count++
+	h.Total += d
+}
+
+// ChannelStats counts the traffic of one channel.
+type ChannelStats struct {
+	Channel       string
+	Sent          uint64
+	BytesSent     uint64
+	Received      uint64
+	BytesReceived uint64
+	DecodeErrors  uint64 // messages that could not be unmarshaled
+	HandlerErrors uint64 // handler calls that returned an error
+	Invalid       uint64 // messages rejected by validation
+	Expired       uint64 // messages dropped because their TTL had passed
+	Latency       Histogram
+}
+
+// Stats is a snapshot of a transport's traffic counters.
+type Stats struct {
+	Channels     []ChannelStats // ordered by channel
+	AuthFailures uint64
+}
+
+// Totals sums the counters of every channel. The latency histogram is
+// left empty.
+func (s Stats) Totals() ChannelStats {
+	var total ChannelStats
+	for _, c := range s.Channels {
+		total.Sent += c.Sent
+		total.BytesSent += c.BytesSent
+		total.Received += c.Received
+		total.BytesReceived += c.BytesReceived
+		total.DecodeErrors += c.DecodeErrors
+		total.HandlerErrors += c.HandlerErrors
+		total.Invalid += c.Invalid
+		total.Expired += c.Expired
+	}
+	return total
+}
+
+// traffic collects per-channel counters.
+type traffic struct {
+	mu       sync.Mutex
+	channels map[string]*ChannelStats
+}
+
+// channel returns the counters for ch. Callers hold t.mu.
+func (t *traffic) channel(ch string) *ChannelStats {
+	if ch == "" || strings.HasPrefix(ch, "_INBOX.") {
+		ch = ReplyStatsChannel
+	}
+	if t.channels == nil {
+		t.channels = make(map[string]*ChannelStats)
+	}
+	c := t.channels[ch]
+	if c == nil {
+		c = &ChannelStats{Channel: ch}
+		t.channels[ch] = c
+	}
+	return c
+}
+
+func (t *traffic) sent(ch string, n int) {
+	t.mu.Lock()
+	c := t.channel(ch)
+	c.Sent++
+	c.BytesSent += uint64(n)
+	t.mu.Unlock()
+}
+
+func (t *traffic) received(ch string, n int) {
+	t.mu.Lock()
+	c := t.channel(ch)
+	c.Received++
+	c.BytesReceived += uint64(n)
+	t.mu.Unlock()
+}
+
+func (t *traffic) decodeFailed(ch string) {
+	t.mu.Lock()
+	t.channel(ch).DecodeErrors++
+	t.mu.Unlock()
+}
+
+func (t *traffic) rejected(ch string) {
+	t.mu.Lock()
+	t.channel(ch).Invalid++
+	t.mu.Unlock()
+}
+
+func (t *traffic) expired(ch string) {
+	t.mu.Lock()
+	t.channel(ch).Expired++
+	t.mu.Unlock()
+}
+
+func (t *traffic) handled(ch string, d time.Duration, err error) {
+	t.mu.Lock()
+	c := t.channel(ch)
+	c.Latency.observe(d)
+	if err != nil {
+		c.HandlerErrors++
+	}
+	t.mu.Unlock()
+}
+
+// instrument times handler and counts its errors under the channel each
+// message arrived on.
+func (e *endpoint) instrument(handler SubscriptionHandler) SubscriptionHandler {
+	return func(msg message.Message) error {
+		start := time.Now()
+		err := handler(msg)
+		e.traffic.handled(msg.Channel, time.Since(start), err)
+		return err
+	}
+}
+
+// Stats returns a snapshot of the traffic counters.
+func (e *endpoint) Stats() Stats {
+	e.traffic.mu.Lock()
+	channels := make([]ChannelStats, 0, len(e.traffic.channels))
+	for _, c := range e.traffic.channels {
+		snapshot := *c
+		snapshot.Latency.Counts = append([]uint64(nil), c.Latency.Counts...)
+		channels = append(channels, snapshot)
+	}
+	e.traffic.mu.Unlock()
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Channel < channels[j].Channel })
+	return Stats{Channels: channels, AuthFailures: e.AuthFailures()}
+}
@@ -0,0 +1,317 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"Firetruck-sim/pkg/message"
+)
+
+// orderWait bounds how long a message waits for its position, and a
+// position for its message, before the total order gives up on the other.
+const orderWait = 5 * time.Second
+
+// OrderStats describes the total-order delivery buffer.
+type OrderStats struct {
+	Buffered    int    // messages waiting for their turn right now
+	MaxBuffered int    // most messages ever waiting at once
+	Sequenced   uint64 // positions handed out, on the sequencer only
+	Skipped     uint64 // positions given up on because their message was lost
+	Unordered   uint64 // messages delivered without ever getting a position
+}
+
+// TotalOrderTransport wraps a Transport and delivers messages on selected
+// channels in the same order on every node. Messages are broadcast as
+// usual; one node, the sequencer, also hears them and publishes the
+// position of each on ChannelTotalOrder. Other nodes hold every message
+// back until all earlier positions have been delivered.
+type TotalOrderTransport struct {
+	Transport
+
+	sequencer string
+	channels  map[string]bool
+
+	seqMu    sync.Mutex // keeps positions published in the order handed out
+	assigned uint64
+
+	mu        sync.Mutex
+	epoch     int64  // sequencer start time the positions belong to
+	next      uint64 // next position to deliver, 0 before the first is seen
+	started   bool   // whether a position of this epoch was delivered
+	positions map[uint64]*orderSlot
+	waiting   map[string]*orderedMsg // messages by orderID
+	outbox    []message.Message
+	handlers  map[string][]SubscriptionHandler
+	stats     OrderStats
+
+	dispatchMu sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+type orderSlot struct {
+	id      string
+	arrived time.Time
+}
+
+type orderedMsg struct {
+	msg      message.Message
+	arrived  time.Time
+	position uint64
+}
+
+// NewTotalOrderTransport wraps inner with total-order delivery on channels,
+// using the node with ID sequencer to number messages. Every node must use
+// the same channels and sequencer.
+func NewTotalOrderTransport(inner Transport, sequencer string, channels []string) (*TotalOrderTransport, error) {
+	ot := &TotalOrderTransport{
+		Transport: inner,
+		sequencer: sequencer,
+		channels:  make(map[string]bool),
+		positions: make(map[uint64]*orderSlot),
+		waiting:   make(map[string]*orderedMsg),
+		handlers:  make(map[string][]SubscriptionHandler),
+		done:      make(chan struct{}),
+	}
+
+	if err := inner.Subscribe(ChannelTotalOrder, ot.receivePosition); err != nil {
+		return nil, err
+	}
+	// Listen on every ordered channel, handlers or not, so that the
+	// sequencer numbers everything and nobody misses a position
+	for _, ch := range channels {
+		ot.channels[ch] = true
+		if err := inner.Subscribe(ch, ot.receive); err != nil {
+			return nil, err
+		}
+	}
+
+	go ot.expire()
+	return ot, nil
+}
+
+// orderID identifies a message across nodes by its sender's sequence number.
+func orderID(msg message.Message) string {
+	return fmt.Sprintf("%s %s %d %d", msg.From, msg.Channel, msg.Epoch, msg.Seq)
+}
+
+// Subscribe registers handler for total-order delivery on ordered channels
+// and passes other channels through.
+func (ot *TotalOrderTransport) Subscribe(channel string, handler SubscriptionHandler) error {
+	if !ot.channels[channel] {
+		return ot.Transport.Subscribe(channel, handler)
+	}
+	ot.mu.Lock()
+	ot.handlers[channel] = append(ot.handlers[channel], handler)
+	ot.mu.Unlock()
+	return nil
+}
+
+// OrderStats returns the state of the total-order delivery buffer.
+func (ot *TotalOrderTransport) OrderStats() OrderStats {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+
+	stats := ot.stats
+	stats.Buffered = len(ot.waiting)
+	return stats
+}
+
+// Close stops the delivery buffer and closes the inner transport.
+func (ot *TotalOrderTransport) Close() error {
+	ot.closeOnce.Do(func() { close(ot.done) })
+	return ot.Transport.Close()
+}
+
+// receive buffers a message on an ordered channel until its turn and, on
+// the sequencer, hands it the next position.
+func (ot *TotalOrderTransport) receive(msg message.Message) error {
+	if msg.Seq == 0 {
+		// Unsequenced messages cannot be referred to by position
+		ot.mu.Lock()
+		ot.outbox = append(ot.outbox, msg)
+		ot.mu.Unlock()
+		ot.dispatch()
+		return nil
+	}
+
+	id := orderID(msg)
+	ot.mu.Lock()
+	m := &orderedMsg{msg: msg, arrived: time.Now()}
+	for pos, slot := range ot.positions {
+		if slot.id == id {
+			// The position overtook the message
+			m.position = pos
+		}
+	}
+	ot.waiting[id] = m
+	ot.stats.MaxBuffered = max(ot.stats.MaxBuffered, len(ot.waiting))
+	ot.releaseLocked()
+	ot.mu.Unlock()
+
+	if ot.GetID() == ot.sequencer {
+		if err := ot.assign(id); err != nil {
+			return err
+		}
+	}
+	ot.dispatch()
+	return nil
+}
+
+// assign publishes the next position for the message id.
+func (ot *TotalOrderTransport) assign(id string) error {
+	ot.seqMu.Lock()
+	defer ot.seqMu.Unlock()
+
+	ot.assigned++
+	pos, err := message.New(message.TypeOrder, ot.GetID(), message.Order{Position: ot.assigned, Msg: id})
+	if err != nil {
+		return err
+	}
+	if err := ot.Transport.Publish(ChannelTotalOrder, pos); err != nil {
+		return fmt.Errorf("failed to publish position %d: %w", ot.assigned, err)
+	}
+
+	ot.mu.Lock()
+	ot.stats.Sequenced++
+	ot.mu.Unlock()
+	return nil
+}
+
+// receivePosition records a position from the sequencer.
+func (ot *TotalOrderTransport) receivePosition(msg message.Message) error {
+	if msg.Type != message.TypeOrder || msg.From != ot.sequencer {
+		return nil
+	}
+	order, err := message.Decode[message.Order](msg)
+	if err != nil {
+		return err
+	}
+	pos, id := order.Position, order.Msg
+	if pos == 0 || id == "" {
+		return nil
+	}
+
+	ot.mu.Lock()
+	if msg.Epoch != ot.epoch {
+		// First position seen, or the sequencer restarted and counts
+		// from 1 again
+		ot.epoch = msg.Epoch
+		ot.next = pos
+		ot.started = false
+		clear(ot.positions)
+	}
+	if pos < ot.next && !ot.started {
+		// Positions can arrive out of order; start at the lowest seen
+		ot.next = pos
+	}
+	if pos >= ot.next {
+		ot.positions[pos] = &orderSlot{id: id, arrived: time.Now()}
+		if m := ot.waiting[id]; m != nil {
+			m.position = pos
+		}
+		ot.releaseLocked()
+	}
+	ot.mu.Unlock()
+
+	ot.dispatch()
+	return nil
+}
+
+// releaseLocked moves messages to the outbox while the next position's
+// message is present. Callers hold ot.mu.
+func (ot *TotalOrderTransport) releaseLocked() {
+	for {
+		slot := ot.positions[ot.next]
+		if slot == nil {
+			return
+		}
+		m := ot.waiting[slot.id]
+		if m == nil {
+			return
+		}
+		ot.outbox = append(ot.outbox, m.msg)
+		delete(ot.waiting, slot.id)
+		delete(ot.positions, ot.next)
+		ot.next++
+		ot.started = true
+	}
+}
+
+// dispatch runs handlers for released messages, one at a time and in order.
+func (ot *TotalOrderTransport) dispatch() {
+	ot.dispatchMu.Lock()
+	defer ot.dispatchMu.Unlock()
+
+	for {
+		ot.mu.Lock()
+		if len(ot.outbox) == 0 {
+			ot.mu.Unlock()
+			return
+		}
+		msg := ot.outbox[0]
+		ot.outbox = ot.outbox[1:]
+		handlers := ot.handlers[msg.Channel]
+		ot.mu.Unlock()
+
+		for _, h := range handlers {
+			if err := h(msg); err != nil {
+				fmt.Printf("Error handling broadcast message: %v\n", err)
+			}
+		}
+	}
+}
+
+// expire skips positions whose message or predecessor positions were lost,
+// and delivers messages that never got a position, so that a lost message
+// or a missing sequencer only delays delivery.
+func (ot *TotalOrderTransport) expire() {
+	ticker := time.NewTicker(orderWait / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ot.done:
+			return
+		case <-ticker.C:
+		}
+
+		ot.mu.Lock()
+		released := len(ot.outbox)
+		for ot.skippableLocked() {
+			delete(ot.positions, ot.next)
+			ot.next++
+			ot.stats.Skipped++
+			ot.releaseLocked()
+		}
+		for id, m := range ot.waiting {
+			if m.position == 0 && time.Since(m.arrived) > orderWait {
+				ot.outbox = append(ot.outbox, m.msg)
+				delete(ot.waiting, id)
+				ot.stats.Unordered++
+			}
+		}
+		released = len(ot.outbox) - released
+		ot.mu.Unlock()
+
+		if released > 0 {
+			ot.dispatch()
+		}
+	}
+}
+
+// skippableLocked reports whether the next position has waited too long,
+// for its message or, if the position itself was lost, behind a later one.
+// Callers hold ot.mu.
+func (ot *TotalOrderTransport) skippableLocked() bool {
+	if slot := ot.positions[ot.next]; slot != nil {
+		return time.Since(slot.arrived) > orderWait
+	}
+	for pos, slot := range ot.positions {
+		if pos > ot.next && time.Since(slot.arrived) > orderWait {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,22 @@
 package transport
 
 import (
+	"errors"
+	"strings"
+	"time"
+
 	"Firetruck-sim/pkg/clock"
 	"Firetruck-sim/pkg/message"
 )
 
+// Errors returned by Transport implementations.
+var (
+	ErrClosed       = errors.New("transport closed")
+	ErrTimeout      = errors.New("request timed out")
+	ErrNoResponders = errors.New("no responders for request")
+	ErrNoReplyTo    = errors.New("message has no reply channel")
+)
+
 type Transport interface {
 	// GetID returns the unique identifier of this transport node
 	GetID() string
@@ -12,11 +24,49 @@ type Transport interface {
 	// Publish broadcasts a message to all subscribers of a channel
 	Publish(channel string, msg message.Message) error
 
-	// Subscribe starts listening to broadcast messages on a channel
+	// Republish publishes a message recorded elsewhere, keeping its sender,
+	// Lamport timestamp and sequence number. Messages that expire are signed
+	// again if the transport has a keyring; others keep their signature
+	Republish(channel string, msg message.Message) error
+
+	// Subscribe starts listening to broadcast messages on a channel. The
+	// channel may be a wildcard pattern, see MatchChannel; handlers find the
+	// concrete channel in Message.Channel
 	Subscribe(channel string, handler SubscriptionHandler) error
 
-	// SetClock sets the shared Lamport clock for this transport
-	SetClock(clock *clock.LamportClock)
+	// Send delivers a message to the inbox of a single node
+	Send(to string, msg message.Message) error
+
+	// SubscribeInbox starts listening to messages sent to this node.
+	// Every inbox handler sees every direct message and filters by type.
+	SubscribeInbox(handler SubscriptionHandler) error
+
+	// Request publishes a message on a channel and waits for the first
+	// reply. It returns ErrTimeout if none arrives within timeout.
+	Request(channel string, msg message.Message, timeout time.Duration) (message.Message, error)
+
+	// Reply answers a message received through Request. Unless it already
+	// has a parent, the response follows the request, see Message.Follow
+	Reply(req message.Message, resp message.Message) error
+
+	// SetClock sets the shared clock (Lamport or hybrid) for this transport
+	SetClock(clock clock.Clock)
+
+	// Stats reports per-channel traffic counters and handler latencies
+	Stats() Stats
+
+	// QueueStats reports the delivery queues set up by WithDeliveryQueue
+	QueueStats() []QueueStats
+
+	// SeqStats reports duplicates and lost messages per peer and channel
+	SeqStats() []SeqStats
+
+	// OnDisconnect, OnReconnect and OnClose register callbacks for when the
+	// transport loses its connection, regains it with all subscriptions
+	// restored, or is closed
+	OnDisconnect(fn func(err error))
+	OnReconnect(fn func())
+	OnClose(fn func())
 
 	// Close shuts down the transport
 	Close() error
@@ -25,17 +75,63 @@ type Transport interface {
 // SubscriptionHandler is a function that processes broadcast messages.
 type SubscriptionHandler func(message.Message) error
 
-// Common broadcast channels for coordination
+// InboxChannel returns the channel carrying direct messages for a node.
+func InboxChannel(id string) string {
+	return "node." + id + ".inbox"
+}
+
+// Common broadcast channels for coordination.
+// Bid decisions and RA replies are sent directly to a node's inbox.
 const (
-	ChannelFireAlerts   = "fires.alerts"   // FireAnnounce
+	ChannelFireAlerts   = "fires.alerts"   // FireAlert
 	ChannelFireBids     = "fires.bids"     // Bid
-	ChannelFireDecision = "fires.decision" // BidDecision
+	ChannelFireDecision = "fires.decision" // BidDecision, once the winner acknowledged
 	ChannelTruckStatus  = "trucks.status"  // discovery/heartbeats
 	ChannelWorldTick    = "world.tick"     // optional deterministic ticks
 
 	// Ricart–Agrawala for water (NEW)
 	ChannelWaterReq     = "water.req"
-	ChannelWaterReply   = "water.reply"
 	ChannelWaterRelease = "water.release"
 	ChannelCoordination = "coordination"
+
+	// Request/reply channels
+	ChannelWaterSupply = "water.supply" // refill grants from the water supply
+	ChannelStateQuery  = "state.query"  // snapshot of known fires
+
+	// Per-node traffic and message loss reports, for the observer
+	ChannelStatsReport = "stats.report"
+
+	// Positions assigned by the total-order sequencer
+	ChannelTotalOrder = "order"
+
+	// Chandy–Lamport snapshot markers and the state each node recorded
+	ChannelSnapshotMarker = "snapshot.marker"
+	ChannelSnapshotReport = "snapshot.report"
+
+	// Messages rejected by validation, with the reason
+	ChannelDeadLetter = "deadletter"
+
+	// Control plane: delivered to every node regardless of partitions
+	ChannelControlPartition = "control.partition"
+
+	// Run announcements, shared by all runs on a broker (never namespaced)
+	ChannelRuns = "runs"
 )
+
+// MatchChannel reports whether channel matches pattern. Patterns follow
+// NATS: channels are dot-separated tokens, "*" matches any one token and a
+// final ">" matches one or more remaining tokens, so "water.*" matches
+// "water.req" and "fires.>" matches "fires.alerts".
+func MatchChannel(pattern, channel string) bool {
+	pt := strings.Split(pattern, ".")
+	ct := strings.Split(channel, ".")
+	for i, p := range pt {
+		if p == ">" && i == len(pt)-1 {
+			return len(ct) > i
+		}
+		if i >= len(ct) || (p != "*" && p != ct[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(ct)
+}